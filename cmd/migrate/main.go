@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"log"
+	"oauth2/config"
+	"oauth2/pkg/model"
+)
+
+// 把user表中遗留的明文密码全部哈希
+// 登录时也会自动升级, 这里用于处理长期不登录的用户
+func main() {
+	config.YamlSetup()
+	model.Setup()
+
+	count, err := model.MigratePlaintextPasswords(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Migrated %d plaintext passwords.", count)
+}
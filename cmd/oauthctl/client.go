@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+)
+
+func clientCreate(args []string) error {
+	fs := newFlagSet("client create")
+	id := fs.String("id", "", "client id, generated when empty")
+	name := fs.String("name", "", "client name (required)")
+	domain := fs.String("domain", "", "client domain")
+	var redirectURIs, scopes, grantTypes stringList
+	fs.Var(&redirectURIs, "redirect-uri", "redirect uri, repeatable")
+	fs.Var(&scopes, "scope", "scope as id[:title], repeatable")
+	fs.Var(&grantTypes, "grant-type", "allowed grant type, repeatable, empty for all")
+	public := fs.Bool("public", false, "public client without secret, requires PKCE")
+	requirePKCE := fs.Bool("require-pkce", false, "require PKCE for authorization code")
+	firstParty := fs.Bool("first-party", false, "first-party client, skips the consent screen")
+	fs.Parse(args)
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+
+	ctx := context.Background()
+	if *id == "" {
+		*id = uuid.NewString()
+	} else if _, err := model.GetClientByID(ctx, *id); err == nil {
+		return fmt.Errorf("client %s already exists", *id)
+	}
+	c := &model.Client{
+		ID:           *id,
+		Name:         *name,
+		Domain:       *domain,
+		RedirectURIs: redirectURIs,
+		GrantTypes:   grantTypes,
+		Public:       *public,
+		RequirePKCE:  *requirePKCE,
+		FirstParty:   *firstParty,
+	}
+	for _, s := range scopes {
+		sid, title, _ := strings.Cut(s, ":")
+		c.Scope = append(c.Scope, config.Scope{ID: sid, Title: title})
+	}
+	if c.Public {
+		c.TokenEndpointAuthMethod = oauth2_val.AuthMethodNone
+	}
+	if err := oauth2_val.ValidateClient(c); err != nil {
+		return err
+	}
+	if !c.Public {
+		c.Secret = oauth2_val.RandomToken()
+	}
+	if err := model.SaveClient(ctx, c); err != nil {
+		return err
+	}
+	fmt.Println("client_id:", c.ID)
+	if c.Secret != "" {
+		fmt.Println("client_secret:", c.Secret)
+	}
+	return nil
+}
+
+func clientRotateSecret(args []string) error {
+	fs := newFlagSet("client rotate-secret")
+	id := fs.String("id", "", "client id (required)")
+	fs.Parse(args)
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	ctx := context.Background()
+	c, err := model.GetClientByID(ctx, *id)
+	if err != nil {
+		return fmt.Errorf("client %s: %v", *id, err)
+	}
+	if c.Public {
+		return fmt.Errorf("client %s is public and has no secret", *id)
+	}
+	c.Secret = oauth2_val.RandomToken()
+	if err := model.SaveClient(ctx, c); err != nil {
+		return err
+	}
+	fmt.Println("client_secret:", c.Secret)
+	// 配置文件中的客户端启动时会被覆盖
+	for _, v := range config.GetCfg().OAuth2.Client {
+		if v.ID == c.ID {
+			fmt.Fprintln(os.Stderr, "warning: client is defined in the config file, update its secret there too or it will be restored on next start")
+		}
+	}
+	return nil
+}
+
+func clientList(args []string) error {
+	fs := newFlagSet("client list")
+	name := fs.String("name", "", "filter by name")
+	fs.Parse(args)
+
+	clients, _, err := model.ListClients(context.Background(), model.ClientFilter{Name: *name}, 0, -1)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPUBLIC\tGRANT TYPES\tREDIRECT URIS")
+	for _, c := range clients {
+		grants := strings.Join(c.GrantTypes, ",")
+		if grants == "" {
+			grants = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", c.ID, c.Name, c.Public, grants, strings.Join(c.RedirectURIs, ","))
+	}
+	return w.Flush()
+}
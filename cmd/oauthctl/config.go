@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"oauth2/config"
+)
+
+func configConvert(args []string) error {
+	fs := newFlagSet("config convert")
+	src := fs.String("src", "config.yaml", "source file, .yaml/.yml/.json")
+	dst := fs.String("dst", "config.json", "destination file, .yaml/.yml/.json")
+	fs.Parse(args)
+
+	if err := config.ConfigTypeConv(*dst, *src); err != nil {
+		return err
+	}
+	fmt.Printf("converted %s to %s\n", *src, *dst)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+)
+
+// jwtDecode 只解码header和payload, 用于排查问题
+func jwtDecode(args []string) error {
+	token, err := tokenArg("jwt decode", args)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("token is not a JWT")
+	}
+	for i, name := range []string{"header", "payload"} {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[i], "="))
+		if err != nil {
+			return fmt.Errorf("decode %s: %v", name, err)
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, b, "", "  "); err != nil {
+			return fmt.Errorf("decode %s: %v", name, err)
+		}
+		fmt.Printf("%s:\n%s\n", name, out.String())
+	}
+	return nil
+}
+
+func jwtVerify(args []string) error {
+	token, err := tokenArg("jwt verify", args)
+	if err != nil {
+		return err
+	}
+	claims, err := oauth2_val.ParseAccessToken(token)
+	if err != nil {
+		return fmt.Errorf("invalid token: %v", err)
+	}
+	b, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("valid token:\n%s\n", b)
+	return nil
+}
+
+func tokenArg(name string, args []string) (string, error) {
+	fs := newFlagSet(name)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: oauthctl %s <token>\n", name)
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return "", errors.New("exactly one token is required")
+	}
+	return fs.Arg(0), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+)
+
+// oauthctl 运维命令行工具
+// 与 server 读取同一个 config.yaml, 直接操作数据库和 token 存储, 不需要 server 在运行
+//
+//	oauthctl [-config config.yaml] <command> <action> [flags]
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config file")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]+" "+args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s %s\n\n", args[0], args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if cmd.setup != nil {
+		if err := config.LoadYaml(*configPath); err != nil {
+			fatal(err)
+		}
+		cmd.setup()
+	}
+	if err := cmd.run(args[2:]); err != nil {
+		fatal(err)
+	}
+}
+
+type command struct {
+	usage string
+	// setup 运行前需要初始化的资源, 为nil时不读取配置文件
+	setup func()
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"user create":          {"创建用户", setupDB, userCreate},
+	"user disable":         {"禁用用户, 撤销其全部token和登录会话", setupTokenStore, userDisable},
+	"user enable":          {"启用用户", setupDB, userEnable},
+	"user reset-password":  {"重置用户密码并终止其登录会话, 不指定时随机生成", setupDB, userResetPassword},
+	"client create":        {"注册客户端", setupDB, clientCreate},
+	"client rotate-secret": {"重新生成客户端的secret", setupDB, clientRotateSecret},
+	"client list":          {"列出客户端", setupDB, clientList},
+	"token list":           {"列出有效的token", setupOAuth2, tokenList},
+	"token revoke":         {"撤销指定的token, 或者某个客户端/用户的全部token", setupOAuth2, tokenRevoke},
+	"config convert":       {"转换配置文件格式(yaml/json)", nil, configConvert},
+	"jwt decode":           {"解码access token, 不验证签名", nil, jwtDecode},
+	"jwt verify":           {"使用配置的签名密钥验证access token", oauth2_val.SetupSigningKeys, jwtVerify},
+}
+
+func setupDB() {
+	model.Setup()
+}
+
+// setupOAuth2 token_store 为 memory 时 token 只存在于 server 进程中,
+// 这里创建的是一个新的空存储, 操作不到 server 签发的 token
+func setupOAuth2() {
+	if !sharedTokenStore() {
+		fatal(errMemoryTokenStore)
+	}
+	setupTokenStore()
+}
+
+// setupTokenStore 同 setupOAuth2, token_store 为 memory 时也继续, 由命令自己报告没有撤销的 token
+func setupTokenStore() {
+	model.Setup()
+	oauth2_val.Setup(context.Background())
+}
+
+var errMemoryTokenStore = errors.New("token_store is memory, tokens only live in the server process; use db or redis")
+
+// sharedTokenStore token 存储是否可以在 server 进程之外访问
+func sharedTokenStore() bool {
+	switch config.GetCfg().OAuth2.TokenStore {
+	case "redis", "db", "mysql":
+		return true
+	}
+	return false
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: oauthctl [-config config.yaml] <command> <action> [flags]")
+	fmt.Fprintln(os.Stderr)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run 'oauthctl <command> <action> -h' for flags")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+// newFlagSet 子命令的参数
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("oauthctl "+name, flag.ExitOnError)
+}
+
+// stringList 可以重复指定的参数, 也支持逗号分隔
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/storage"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func tokenList(args []string) error {
+	fs := newFlagSet("token list")
+	clientID := fs.String("client-id", "", "filter by client id")
+	userID := fs.String("user-id", "", "filter by user id")
+	limit := fs.Int("limit", 100, "max tokens to list")
+	fs.Parse(args)
+
+	filter := storage.TokenFilter{ClientID: *clientID, UserID: *userID}
+	tokens, total, err := oauth2_val.ListTokens(context.Background(), filter, 0, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tUSER\tSCOPE\tCREATED\tEXPIRES\tREFRESH")
+	for _, ti := range tokens {
+		expires := "never"
+		if exp := ti.GetAccessExpiresIn(); exp > 0 {
+			expires = ti.GetAccessCreateAt().Add(exp).Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", ti.GetClientID(), ti.GetUserID(), ti.GetScope(),
+			ti.GetAccessCreateAt().Format(time.RFC3339), expires, ti.GetRefresh() != "")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if int64(len(tokens)) < total {
+		fmt.Printf("showing %d of %d tokens\n", len(tokens), total)
+	}
+	return nil
+}
+
+func tokenRevoke(args []string) error {
+	fs := newFlagSet("token revoke")
+	token := fs.String("token", "", "access token or refresh token to revoke")
+	clientID := fs.String("client-id", "", "revoke all tokens of the client")
+	userID := fs.String("user-id", "", "revoke all tokens of the user")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	if *token != "" {
+		ok, err := oauth2_val.AdminRevokeToken(ctx, *token)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("token not found")
+		}
+		fmt.Println("revoked 1 token")
+		return nil
+	}
+	// 至少需要一个过滤条件, 避免误撤销全部token
+	if *clientID == "" && *userID == "" {
+		return errors.New("one of -token, -client-id or -user-id is required")
+	}
+	n, err := oauth2_val.RevokeTokens(ctx, storage.TokenFilter{ClientID: *clientID, UserID: *userID})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("revoked %d tokens\n", n)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/storage"
+	"strconv"
+)
+
+func userCreate(args []string) error {
+	fs := newFlagSet("user create")
+	username := fs.String("username", "", "username (required)")
+	password := fs.String("password", "", "password, generated when empty")
+	email := fs.String("email", "", "email")
+	phone := fs.String("phone", "", "phone")
+	fs.Parse(args)
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	ctx := context.Background()
+	if _, err := model.GetUserByUsername(ctx, *username); err == nil {
+		return fmt.Errorf("user %s already exists", *username)
+	}
+	u := &model.User{Username: *username, Email: *email, Phone: *phone}
+	plain, err := setPassword(u, *password)
+	if err != nil {
+		return err
+	}
+	if err := model.SaveUser(ctx, u); err != nil {
+		return err
+	}
+	fmt.Printf("created user %s (id %d)\n", u.Username, u.ID)
+	if *password == "" {
+		fmt.Println("password:", plain)
+	}
+	return nil
+}
+
+func userDisable(args []string) error {
+	u, err := loadUser("user disable", args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	u.Disabled = true
+	if err := model.SaveUser(ctx, u); err != nil {
+		return err
+	}
+	userID := strconv.Itoa(int(u.ID))
+	sessions, err := model.DeleteSSOSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("disabled user %s, terminated %d sessions\n", u.Username, sessions)
+	// 用户已经禁用, 不能登录; 没能撤销的 token 在过期之前仍然有效
+	if !sharedTokenStore() {
+		return errors.New("tokens of the user were not revoked: " + errMemoryTokenStore.Error())
+	}
+	n, err := oauth2_val.RevokeTokens(ctx, storage.TokenFilter{UserID: userID})
+	if err != nil {
+		return errors.New("tokens of the user were not revoked: " + err.Error())
+	}
+	fmt.Printf("revoked %d tokens\n", n)
+	return nil
+}
+
+func userEnable(args []string) error {
+	u, err := loadUser("user enable", args)
+	if err != nil {
+		return err
+	}
+	u.Disabled = false
+	if err := model.SaveUser(context.Background(), u); err != nil {
+		return err
+	}
+	fmt.Printf("enabled user %s\n", u.Username)
+	return nil
+}
+
+func userResetPassword(args []string) error {
+	fs := newFlagSet("user reset-password")
+	username := fs.String("username", "", "username (required)")
+	password := fs.String("password", "", "new password, generated when empty")
+	fs.Parse(args)
+
+	u, err := getUser(*username)
+	if err != nil {
+		return err
+	}
+	plain, err := setPassword(u, *password)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := model.SaveUser(ctx, u); err != nil {
+		return err
+	}
+	// 用旧密码登录的浏览器需要重新登录
+	sessions, err := model.DeleteSSOSessions(ctx, strconv.Itoa(int(u.ID)))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reset password of user %s, terminated %d sessions\n", u.Username, sessions)
+	if *password == "" {
+		fmt.Println("password:", plain)
+	}
+	return nil
+}
+
+// loadUser 解析只有 -username 参数的子命令
+func loadUser(name string, args []string) (*model.User, error) {
+	fs := newFlagSet(name)
+	username := fs.String("username", "", "username (required)")
+	fs.Parse(args)
+	return getUser(*username)
+}
+
+func getUser(username string) (*model.User, error) {
+	if username == "" {
+		return nil, errors.New("-username is required")
+	}
+	u, err := model.GetUserByUsername(context.Background(), username)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %v", username, err)
+	}
+	return u, nil
+}
+
+// setPassword 设置密码, 为空时随机生成, 返回明文
+func setPassword(u *model.User, plain string) (string, error) {
+	if plain == "" {
+		plain = oauth2_val.RandomToken()[:16]
+	}
+	return plain, u.SetPassword(plain)
+}
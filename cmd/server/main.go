@@ -18,7 +18,7 @@ func main() {
 	r := gin.Default()
 	config.YamlSetup()
 	model.Setup()
-	session.Setup()
+	session.Setup(ctx)
 	oauth2_val.Setup(ctx)
 	router.Setup(r)
 
@@ -5,10 +5,14 @@ type App struct {
 		Name      string `yaml:"name"`
 		SecretKey string `yaml:"secret_key"`
 		MaxAge    int    `yaml:"max_age"`
+		// session数据的存储方式: cookie memory redis db, 默认 cookie
+		Store string `yaml:"store"`
 	} `yaml:"session"`
 
 	AuthMode string `yaml:"auth_mode"`
 
+	Password Password `yaml:"password"`
+
 	DB struct {
 		Default DB `yaml:"default"`
 	} `yaml:"db"`
@@ -20,13 +24,62 @@ type App struct {
 	} `yaml:"redis"`
 
 	OAuth2 struct {
+		Issuer         string         `yaml:"issuer"`
 		AccessTokenExp int            `yaml:"access_token_exp"`
 		JWTSignedKey   string         `yaml:"jwt_signed_key"`
+		SigningKeys    []SigningKey   `yaml:"signing_keys"`
 		TokenStore     string         `yaml:"token_store"`
 		Client         []OAuth2Client `yaml:"client"`
+		Registration   Registration   `yaml:"registration"`
+		// 用户同意授权的有效期, 单位天, 默认30天
+		ConsentExp int `yaml:"consent_exp"`
+		// 设备授权(device_code)的有效期, 单位秒, 默认600秒
+		DeviceCodeExp int `yaml:"device_code_exp"`
+		// discovery 文档中公开的 scope, 为空时只公开 OpenID Connect 的标准 scope
+		ScopesSupported []string `yaml:"scopes_supported"`
+		// 使用其他身份系统签发的 JWT 换取 access token
+		JWTBearer JWTBearer `yaml:"jwt_bearer"`
 	} `yaml:"oauth2"`
 }
 
+// JWTBearer jwt-bearer 授权方式 (RFC 7523 2.1) 信任的签发方
+type JWTBearer struct {
+	Issuers []TrustedIssuer `yaml:"issuers"`
+}
+
+// TrustedIssuer 信任的 JWT 签发方, 按 assertion 的 iss 匹配
+type TrustedIssuer struct {
+	Issuer string `yaml:"issuer"`
+	// 验证签名的公钥, 直接配置 JWK Set 的 JSON 或者从本地文件读取
+	JWKS     string `yaml:"jwks"`
+	JWKSFile string `yaml:"jwks_file"`
+	// assertion 的 sub 对应用户的哪个字段: username(默认) email
+	SubjectMapping string `yaml:"subject_mapping"`
+	// 可以提交该签发方 assertion 的客户端, 为空时不能使用
+	Clients []string `yaml:"clients"`
+	// 请求中没有指定 scope 时授予的权限范围, 为空时必须指定 scope
+	DefaultScope []string `yaml:"default_scope"`
+}
+
+// Registration 动态客户端注册 (RFC 7591)
+type Registration struct {
+	// 调用 /register 时需要携带的 initial access token, 为空时不开放动态注册
+	InitialAccessTokens []string `yaml:"initial_access_tokens"`
+	// 动态注册的客户端可以申请的权限范围
+	Scope []Scope `yaml:"scope"`
+}
+
+// Password 用户密码的哈希方式
+type Password struct {
+	Algorithm  string `yaml:"algorithm"`
+	BcryptCost int    `yaml:"bcrypt_cost"`
+	Argon2     struct {
+		Memory      uint32 `yaml:"memory"`
+		Iterations  uint32 `yaml:"iterations"`
+		Parallelism uint8  `yaml:"parallelism"`
+	} `yaml:"argon2"`
+}
+
 type DB struct {
 	Type     string `yaml:"type"`
 	Host     string `yaml:"host"`
@@ -34,12 +87,15 @@ type DB struct {
 	UserName string `yaml:"username"`
 	Password string `yaml:"password"`
 	DBName   string `yaml:"dbname"`
+	// 仅 postgres 使用, 默认 disable
+	SSLMode string `yaml:"sslmode"`
 }
 
 type Redis struct {
-	Addr     string `yaml:"addr"`
-	Password string `yaml:"password"`
-	DB       int    `yaml:"db"`
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
 }
 
 type OAuth2Client struct {
@@ -48,11 +104,49 @@ type OAuth2Client struct {
 	Name   string  `yaml:"name"`
 	Domain string  `yaml:"domain"`
 	Scope  []Scope `yaml:"scope"`
+	// 应用图标和主页, 在登录页面展示
+	LogoURI   string `yaml:"logo_uri"`
+	ClientURI string `yaml:"client_uri"`
+	// 允许使用的授权方式, 为空时不限制
+	GrantTypes []string `yaml:"grant_types"`
+	// 允许的回调地址, 精确匹配
+	RedirectURIs []string `yaml:"redirect_uris"`
+	// 回调地址为回环地址(127.0.0.1/[::1])时允许任意端口 (RFC 8252), 用于本地原生应用
+	LoopbackAnyPort bool `yaml:"loopback_any_port"`
+	// 公开客户端(SPA/移动端等无法保存secret的应用), 不校验secret, 强制使用PKCE
+	Public bool `yaml:"public"`
+	// 授权码模式强制使用PKCE
+	RequirePKCE bool `yaml:"require_pkce"`
+	// 访问token端点时的认证方式, 为空时 basic 和表单都可以
+	// client_secret_basic 只能使用 basic auth, client_secret_post 只能在表单中提交 secret
+	// private_key_jwt 和 client_secret_jwt 使用 client_assertion 认证, 不能再直接使用 secret
+	TokenEndpointAuthMethod string `yaml:"token_endpoint_auth_method"`
+	// private_key_jwt 使用的公钥, 直接配置 JWK Set 的 JSON 或者从本地文件读取
+	JWKS     string `yaml:"jwks"`
+	JWKSFile string `yaml:"jwks_file"`
+	// 自己的应用, 不需要用户确认授权
+	FirstParty bool `yaml:"first_party"`
+	// refresh token 的绝对有效期, 单位小时, 从第一次授权开始计算, 刷新不会延长
+	// 为0时使用默认值: 授权码模式3天, 密码模式和设备授权7天
+	RefreshTokenAbsoluteExp int `yaml:"refresh_token_absolute_exp"`
+	// refresh token 的滑动有效期, 单位小时, 超过这个时间没有刷新就失效, 为0时不限制
+	RefreshTokenSlidingExp int `yaml:"refresh_token_sliding_exp"`
+	// token exchange 时可以申请的受众(下游服务的client_id), 为空时不能使用 token exchange
+	TokenExchangeAudiences []string `yaml:"token_exchange_audiences"`
+}
+
+// SigningKey 签名密钥, 非对称密钥的公钥会通过 /.well-known/jwks.json 公开
+type SigningKey struct {
+	KID            string `yaml:"kid"`
+	Alg            string `yaml:"alg"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	Secret         string `yaml:"secret"`
+	ActiveAt       string `yaml:"active_at"`
 }
 
 type Scope struct {
-	ID    string `yaml:"id"`
-	Title string `yaml:"title"`
+	ID    string `yaml:"id" json:"id"`
+	Title string `yaml:"title" json:"title"`
 }
 
 type LDAP struct {
@@ -61,4 +155,14 @@ type LDAP struct {
 	SearchPassword string `yaml:"search_password"`
 	BaseDN         string `yaml:"base_dn"`
 	Filter         string `yaml:"filter"`
+
+	Attributes LDAPAttributes `yaml:"attributes"`
+}
+
+// LDAPAttributes 用户信息与LDAP属性的映射关系, 为空时使用默认属性
+type LDAPAttributes struct {
+	Username string `yaml:"username"`
+	Email    string `yaml:"email"`
+	Phone    string `yaml:"phone"`
+	Avatar   string `yaml:"avatar"`
 }
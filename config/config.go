@@ -20,15 +20,18 @@ func YamlSetup() {
 	if path == nil {
 		panic("config file path is nil")
 	}
-	cfgBytes, err := os.ReadFile(*path)
-	if err != nil {
+	if err := LoadYaml(*path); err != nil {
 		panic(err)
 	}
+}
 
-	err = yaml.Unmarshal(cfgBytes, &cfg)
+// LoadYaml 从指定路径加载yaml配置, 供自己解析命令行参数的程序使用
+func LoadYaml(path string) error {
+	cfgBytes, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return yaml.Unmarshal(cfgBytes, &cfg)
 }
 
 func JsonSetup() {
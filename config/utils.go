@@ -6,8 +6,20 @@ func GetCfg() *App {
 	return &cfg
 }
 
+// clientLoader 客户端的加载方式, 为nil时从配置文件中查找
+var clientLoader func(clientID string) *OAuth2Client
+
+// SetClientLoader 设置客户端的加载方式
+// 启动时 model 会设置为从数据库读取
+func SetClientLoader(fn func(clientID string) *OAuth2Client) {
+	clientLoader = fn
+}
+
 // GetOAuth2Client 通过clientID获取客户端
 func GetOAuth2Client(clientID string) *OAuth2Client {
+	if clientLoader != nil {
+		return clientLoader(clientID)
+	}
 	for _, client := range cfg.OAuth2.Client {
 		if client.ID == clientID {
 			return &client
@@ -16,6 +28,19 @@ func GetOAuth2Client(clientID string) *OAuth2Client {
 	return nil
 }
 
+// AllowsGrantType 客户端是否允许使用该授权方式, 没有配置时不限制
+func (c *OAuth2Client) AllowsGrantType(grantType string) bool {
+	if len(c.GrantTypes) == 0 {
+		return true
+	}
+	for _, v := range c.GrantTypes {
+		if v == grantType {
+			return true
+		}
+	}
+	return false
+}
+
 // JoinScope 把一组scope拼接成一个字符串
 func JoinScope(scope []Scope) string {
 	var s []string
@@ -25,6 +50,23 @@ func JoinScope(scope []Scope) string {
 	return strings.Join(s, ",")
 }
 
+// SplitScope 拆分scope字符串, 兼容逗号和空格(OIDC)两种分隔方式
+func SplitScope(scope string) []string {
+	return strings.FieldsFunc(scope, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// HasScope 判断scope字符串中是否包含指定的scope
+func HasScope(scope string, id string) bool {
+	for _, s := range SplitScope(scope) {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
 // ScopeFilter 使用一个scope字符串过滤一个client的权限范围
 func ScopeFilter(clientID string, scope string) []Scope {
 	result := make([]Scope, 0)
@@ -32,8 +74,7 @@ func ScopeFilter(clientID string, scope string) []Scope {
 	if cli == nil {
 		return nil
 	}
-	splitScope := strings.Split(scope, ",")
-	for _, str := range splitScope {
+	for _, str := range SplitScope(scope) {
 		for _, s := range cli.Scope {
 			if s.ID == str {
 				result = append(result, s)
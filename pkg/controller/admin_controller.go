@@ -0,0 +1,490 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/storage"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AdminAuth 管理接口的鉴权中间件, access token 需要包含 admin scope
+func AdminAuth(ctx *gin.Context) {
+	if _, err := oauth2_val.ValidationAdminRequest(ctx.Request); err != nil {
+		bearerError(ctx, err)
+		return
+	}
+	ctx.Next()
+}
+
+// pagination 从查询参数中获取分页, 默认第1页每页20条, 每页最多100条
+func pagination(ctx *gin.Context) (page, pageSize int) {
+	page, _ = strconv.Atoi(ctx.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ = strconv.Atoi(ctx.Query("page_size"))
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	return
+}
+
+func pageResponse(ctx *gin.Context, items interface{}, total int64, page, pageSize int) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func adminError(ctx *gin.Context, status int, code, description string) {
+	ctx.AbortWithStatusJSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// adminServerError 记录日志, 不把内部错误返回给调用方
+func adminServerError(ctx *gin.Context, err error) {
+	log.Println("Admin api error:", err)
+	adminError(ctx, http.StatusInternalServerError, "server_error", "服务器内部错误")
+}
+
+type userRequest struct {
+	Username string `json:"username" binding:"required"`
+	// 为空时不修改密码
+	Password string `json:"password"`
+	Avatar   string `json:"avatar"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	Disabled bool   `json:"disabled"`
+}
+
+// ListUsersHandler 分页查询用户, 支持按 username 和 email 模糊查询
+func ListUsersHandler(ctx *gin.Context) {
+	page, pageSize := pagination(ctx)
+	filter := model.UserFilter{Username: ctx.Query("username"), Email: ctx.Query("email")}
+	users, total, err := model.ListUsers(ctx.Request.Context(), filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	pageResponse(ctx, users, total, page, pageSize)
+}
+
+// GetUserHandler 获取用户
+func GetUserHandler(ctx *gin.Context) {
+	u, ok := loadUser(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, u)
+}
+
+// CreateUserHandler 创建用户, 密码按配置的算法哈希后保存
+func CreateUserHandler(ctx *gin.Context) {
+	req := new(userRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		adminError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if _, err := model.GetUserByUsername(ctx.Request.Context(), req.Username); err == nil {
+		adminError(ctx, http.StatusConflict, "conflict", "用户名已存在")
+		return
+	}
+
+	u := new(model.User)
+	if !applyUserRequest(ctx, u, req) {
+		return
+	}
+	if err := model.SaveUser(ctx.Request.Context(), u); err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, u)
+}
+
+// UpdateUserHandler 更新用户, password 为空时不修改密码
+func UpdateUserHandler(ctx *gin.Context) {
+	u, ok := loadUser(ctx)
+	if !ok {
+		return
+	}
+	req := new(userRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		adminError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if other, err := model.GetUserByUsername(ctx.Request.Context(), req.Username); err == nil && other.ID != u.ID {
+		adminError(ctx, http.StatusConflict, "conflict", "用户名已存在")
+		return
+	}
+
+	if !applyUserRequest(ctx, u, req) {
+		return
+	}
+	if err := model.SaveUser(ctx.Request.Context(), u); err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	// 禁用的用户立即失去访问权限
+	if u.Disabled {
+		if _, err := model.DeleteSSOSessions(ctx.Request.Context(), strconv.Itoa(int(u.ID))); err != nil {
+			log.Println("Delete sso sessions error:", err)
+		}
+		if nr := revokeTokens(ctx, storage.TokenFilter{UserID: strconv.Itoa(int(u.ID))}); nr != nil {
+			ctx.JSON(http.StatusOK, struct {
+				*model.User
+				*tokensNotRevoked
+			}{u, nr})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, u)
+}
+
+// DeleteUserHandler 删除用户, 并撤销该用户的全部token
+func DeleteUserHandler(ctx *gin.Context) {
+	u, ok := loadUser(ctx)
+	if !ok {
+		return
+	}
+	if err := model.DeleteUser(ctx.Request.Context(), strconv.Itoa(int(u.ID))); err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	if nr := revokeTokens(ctx, storage.TokenFilter{UserID: strconv.Itoa(int(u.ID))}); nr != nil {
+		ctx.JSON(http.StatusOK, nr)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListUserSessionsHandler 列出用户的登录会话
+func ListUserSessionsHandler(ctx *gin.Context) {
+	u, ok := loadUser(ctx)
+	if !ok {
+		return
+	}
+	sessions, err := model.ListSSOSessions(ctx.Request.Context(), strconv.Itoa(int(u.ID)))
+	if err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"items": sessions})
+}
+
+// DeleteUserSessionsHandler 终止用户的全部登录会话, 比如重置密码之后
+// 已经签发的token不受影响, 需要时再调用 DELETE /tokens?user_id=
+func DeleteUserSessionsHandler(ctx *gin.Context) {
+	u, ok := loadUser(ctx)
+	if !ok {
+		return
+	}
+	n, err := model.DeleteSSOSessions(ctx.Request.Context(), strconv.Itoa(int(u.ID)))
+	if err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"terminated": n})
+}
+
+func loadUser(ctx *gin.Context) (*model.User, bool) {
+	u, err := model.GetUserByID(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			adminError(ctx, http.StatusNotFound, "not_found", "用户不存在")
+		} else {
+			adminServerError(ctx, err)
+		}
+		return nil, false
+	}
+	return u, true
+}
+
+func applyUserRequest(ctx *gin.Context, u *model.User, req *userRequest) bool {
+	u.Username = req.Username
+	u.Avatar = req.Avatar
+	u.Email = req.Email
+	u.Phone = req.Phone
+	u.Disabled = req.Disabled
+	if req.Password != "" {
+		if err := u.SetPassword(req.Password); err != nil {
+			adminServerError(ctx, err)
+			return false
+		}
+	}
+	return true
+}
+
+type clientRequest struct {
+	ID string `json:"client_id"`
+	// 为空时: 创建非公开客户端会自动生成, 更新时不修改
+	Secret          string         `json:"client_secret"`
+	Name            string         `json:"client_name"`
+	Domain          string         `json:"domain"`
+	LogoURI         string         `json:"logo_uri"`
+	ClientURI       string         `json:"client_uri"`
+	RedirectURIs    []string       `json:"redirect_uris"`
+	Scope           []config.Scope `json:"scope"`
+	GrantTypes      []string       `json:"grant_types"`
+	LoopbackAnyPort bool           `json:"loopback_any_port"`
+	Public          bool           `json:"public"`
+	RequirePKCE     bool           `json:"require_pkce"`
+	FirstParty      bool           `json:"first_party"`
+
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	JWKS                    *jwk.Set `json:"jwks"`
+	JWKSFile                string   `json:"jwks_file"`
+	RefreshTokenAbsoluteExp int      `json:"refresh_token_absolute_exp"`
+	RefreshTokenSlidingExp  int      `json:"refresh_token_sliding_exp"`
+	TokenExchangeAudiences  []string `json:"token_exchange_audiences"`
+}
+
+// clientResponse 创建客户端或修改secret时返回一次 client_secret
+type clientResponse struct {
+	*model.Client
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// ListClientsHandler 分页查询客户端, 支持按 client_id 精确查询和 name 模糊查询
+func ListClientsHandler(ctx *gin.Context) {
+	page, pageSize := pagination(ctx)
+	filter := model.ClientFilter{ID: ctx.Query("client_id"), Name: ctx.Query("name")}
+	clients, total, err := model.ListClients(ctx.Request.Context(), filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	pageResponse(ctx, clients, total, page, pageSize)
+}
+
+// GetClientHandler 获取客户端
+func GetClientHandler(ctx *gin.Context) {
+	c, ok := loadClient(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, c)
+}
+
+// CreateClientHandler 创建客户端, 没有指定 client_id 时自动生成
+func CreateClientHandler(ctx *gin.Context) {
+	req := new(clientRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		adminError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if req.ID == "" {
+		req.ID = uuid.NewString()
+	} else if _, err := model.GetClientByID(ctx.Request.Context(), req.ID); err == nil {
+		adminError(ctx, http.StatusConflict, "conflict", "client_id 已存在")
+		return
+	}
+
+	c := &model.Client{ID: req.ID}
+	saveClient(ctx, c, req, http.StatusCreated)
+}
+
+// UpdateClientHandler 整体替换客户端的配置, client_secret 为空时不修改
+// 配置文件中的客户端在服务重启后会恢复为配置文件中的内容
+func UpdateClientHandler(ctx *gin.Context) {
+	c, ok := loadClient(ctx)
+	if !ok {
+		return
+	}
+	req := new(clientRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		adminError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	saveClient(ctx, c, req, http.StatusOK)
+}
+
+// DeleteClientHandler 删除客户端, 并撤销该客户端的全部token
+func DeleteClientHandler(ctx *gin.Context) {
+	c, ok := loadClient(ctx)
+	if !ok {
+		return
+	}
+	if err := model.DeleteClient(ctx.Request.Context(), c.ID); err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	if nr := revokeTokens(ctx, storage.TokenFilter{ClientID: c.ID}); nr != nil {
+		ctx.JSON(http.StatusOK, nr)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func loadClient(ctx *gin.Context) (*model.Client, bool) {
+	c, err := model.GetClientByID(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			adminError(ctx, http.StatusNotFound, "not_found", "客户端不存在")
+		} else {
+			adminServerError(ctx, err)
+		}
+		return nil, false
+	}
+	return c, true
+}
+
+func saveClient(ctx *gin.Context, c *model.Client, req *clientRequest, status int) {
+	c.Name = req.Name
+	c.Domain = req.Domain
+	c.LogoURI = req.LogoURI
+	c.ClientURI = req.ClientURI
+	c.RedirectURIs = req.RedirectURIs
+	c.Scope = req.Scope
+	c.GrantTypes = req.GrantTypes
+	c.LoopbackAnyPort = req.LoopbackAnyPort
+	c.Public = req.Public || req.TokenEndpointAuthMethod == oauth2_val.AuthMethodNone
+	c.RequirePKCE = req.RequirePKCE
+	c.FirstParty = req.FirstParty
+	c.TokenEndpointAuthMethod = req.TokenEndpointAuthMethod
+	c.JWKS = req.JWKS
+	c.JWKSFile = req.JWKSFile
+	c.RefreshTokenAbsoluteExp = req.RefreshTokenAbsoluteExp
+	c.RefreshTokenSlidingExp = req.RefreshTokenSlidingExp
+	c.TokenExchangeAudiences = req.TokenExchangeAudiences
+	if err := oauth2_val.ValidateClient(c); err != nil {
+		registrationError(ctx, err)
+		return
+	}
+
+	secret := ""
+	switch {
+	case c.Public:
+		c.Secret = ""
+	case req.Secret != "":
+		c.Secret, secret = req.Secret, req.Secret
+	case c.Secret == "":
+		c.Secret = oauth2_val.RandomToken()
+		secret = c.Secret
+	}
+	if err := model.SaveClient(ctx.Request.Context(), c); err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	ctx.JSON(status, clientResponse{Client: c, ClientSecret: secret})
+}
+
+// tokenSummary 列出token时返回的信息, 不包含token本身
+type tokenSummary struct {
+	ClientID         string     `json:"client_id"`
+	UserID           string     `json:"user_id"`
+	Scope            string     `json:"scope"`
+	CreatedAt        time.Time  `json:"created_at"`
+	AccessExpiresAt  *time.Time `json:"access_expires_at"`
+	RefreshExpiresAt *time.Time `json:"refresh_expires_at"`
+}
+
+func newTokenSummary(ti oauth2.TokenInfo) tokenSummary {
+	v := tokenSummary{
+		ClientID:  ti.GetClientID(),
+		UserID:    ti.GetUserID(),
+		Scope:     ti.GetScope(),
+		CreatedAt: ti.GetAccessCreateAt(),
+	}
+	// 不过期的为null
+	if exp := ti.GetAccessExpiresIn(); exp > 0 {
+		t := ti.GetAccessCreateAt().Add(exp)
+		v.AccessExpiresAt = &t
+	}
+	if ti.GetRefresh() != "" && ti.GetRefreshExpiresIn() > 0 {
+		t := ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn())
+		v.RefreshExpiresAt = &t
+	}
+	return v
+}
+
+// ListTokensHandler 分页列出有效的token, 支持按 client_id 和 user_id 过滤
+func ListTokensHandler(ctx *gin.Context) {
+	page, pageSize := pagination(ctx)
+	filter := storage.TokenFilter{ClientID: ctx.Query("client_id"), UserID: ctx.Query("user_id")}
+	tokens, total, err := oauth2_val.ListTokens(ctx.Request.Context(), filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		tokenListError(ctx, err)
+		return
+	}
+	items := make([]tokenSummary, 0, len(tokens))
+	for _, ti := range tokens {
+		items = append(items, newTokenSummary(ti))
+	}
+	pageResponse(ctx, items, total, page, pageSize)
+}
+
+// RevokeTokensHandler 撤销某个客户端或用户的全部token
+// client_id 和 user_id 至少需要一个, 避免误撤销全部token
+func RevokeTokensHandler(ctx *gin.Context) {
+	filter := storage.TokenFilter{ClientID: ctx.Query("client_id"), UserID: ctx.Query("user_id")}
+	if filter.ClientID == "" && filter.UserID == "" {
+		adminError(ctx, http.StatusBadRequest, "invalid_request", "client_id 和 user_id 至少需要一个")
+		return
+	}
+	n, err := oauth2_val.RevokeTokens(ctx.Request.Context(), filter)
+	if err != nil {
+		tokenListError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"revoked": n})
+}
+
+// RevokeTokenHandler 撤销指定的 access token 或 refresh token
+func RevokeTokenHandler(ctx *gin.Context) {
+	var req struct {
+		Token string `json:"token" form:"token" binding:"required"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		adminError(ctx, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	ok, err := oauth2_val.AdminRevokeToken(ctx.Request.Context(), req.Token)
+	if err != nil {
+		adminServerError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"revoked": ok})
+}
+
+func tokenListError(ctx *gin.Context, err error) {
+	if err == oauth2_val.ErrTokenListUnsupported {
+		adminError(ctx, http.StatusNotImplemented, "not_implemented", "当前的 token_store 不支持列出 token")
+		return
+	}
+	adminServerError(ctx, err)
+}
+
+// tokensNotRevoked 删除或禁用之后没能撤销 token 时附加在响应中
+type tokensNotRevoked struct {
+	TokensRevoked bool   `json:"tokens_revoked"`
+	Warning       string `json:"warning"`
+}
+
+// revokeTokens 删除或禁用用户/客户端后撤销其token
+// 不能撤销时返回说明, 由调用方告知调用者这些token在过期之前仍然有效
+func revokeTokens(ctx *gin.Context, filter storage.TokenFilter) *tokensNotRevoked {
+	_, err := oauth2_val.RevokeTokens(ctx.Request.Context(), filter)
+	if err == nil {
+		return nil
+	}
+	if err == oauth2_val.ErrTokenListUnsupported {
+		return &tokensNotRevoked{Warning: "当前的 token_store 不支持撤销 token, 已签发的 token 在过期之前仍然有效"}
+	}
+	log.Println("Revoke tokens error:", err)
+	return &tokensNotRevoked{Warning: "撤销 token 失败, 已签发的 token 在过期之前仍然有效"}
+}
@@ -0,0 +1,249 @@
+package controller_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/router"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-oauth2/oauth2/v4"
+	"gorm.io/gorm"
+)
+
+func setupAdmin(t *testing.T) *gin.Engine {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(model.User{}, model.Client{}, model.Consent{}, model.SSOSession{}); err != nil {
+		t.Fatal(err)
+	}
+	model.GlobalDB = db
+
+	cfg := config.GetCfg()
+	old, oldDB := cfg.OAuth2, cfg.DB
+	t.Cleanup(func() {
+		cfg.OAuth2, cfg.DB = old, oldDB
+		model.GlobalDB = nil
+	})
+	cfg.OAuth2.JWTSignedKey = "test_key"
+	cfg.OAuth2.AccessTokenExp = 1
+	// 删除用户和客户端时需要撤销 token, 使用支持列出 token 的存储
+	cfg.OAuth2.TokenStore = "db"
+	cfg.DB.Default.Type = "sqlite"
+	cfg.OAuth2.Client = []config.OAuth2Client{
+		{ID: "ops", Secret: "ops_secret", Scope: []config.Scope{{ID: "admin", Title: "admin"}}},
+		{ID: "app", Secret: "app_secret", Scope: []config.Scope{{ID: "profile", Title: "profile"}}},
+	}
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	oauth2_val.Setup(ctx)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	router.Setup(r)
+	return r
+}
+
+func adminToken(t *testing.T, gt oauth2.GrantType, clientID, secret, userID, scope string) string {
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(context.Background(), gt, &oauth2.TokenGenerateRequest{
+		ClientID:     clientID,
+		ClientSecret: secret,
+		UserID:       userID,
+		Scope:        scope,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ti.GetAccess()
+}
+
+// call 调用管理接口, 返回状态码和解析后的响应
+func call(t *testing.T, r *gin.Engine, token, method, path string, body interface{}) (int, map[string]interface{}) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	resp := map[string]interface{}{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err, w.Body.String())
+		}
+	}
+	return w.Code, resp
+}
+
+func TestAdminAuth(t *testing.T) {
+	r := setupAdmin(t)
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"invalid token", "not-a-token", http.StatusUnauthorized},
+		{"without admin scope", adminToken(t, oauth2.ClientCredentials, "app", "app_secret", "", "profile"), http.StatusForbidden},
+		// 代表用户的 token 即使有 admin scope 也不能使用
+		{"user token with admin scope", adminToken(t, oauth2.PasswordCredentials, "ops", "ops_secret", "1", "admin"), http.StatusForbidden},
+		{"client token with admin scope", adminToken(t, oauth2.ClientCredentials, "ops", "ops_secret", "", "admin"), http.StatusOK},
+	}
+	for _, c := range cases {
+		if code, resp := call(t, r, c.token, http.MethodGet, "/admin/api/users", nil); code != c.want {
+			t.Errorf("%s: got %d %v", c.name, code, resp)
+		}
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	r := setupAdmin(t)
+	token := adminToken(t, oauth2.ClientCredentials, "ops", "ops_secret", "", "admin")
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if code, resp := call(t, r, token, http.MethodPost, "/admin/api/users", gin.H{"username": name, "password": "pw", "email": name + "@example.com"}); code != http.StatusCreated {
+			t.Fatalf("create %s: got %d %v", name, code, resp)
+		}
+	}
+	if code, _ := call(t, r, token, http.MethodPost, "/admin/api/users", gin.H{"username": "alice"}); code != http.StatusConflict {
+		t.Error("duplicate username should be rejected:", code)
+	}
+	if code, _ := call(t, r, token, http.MethodPost, "/admin/api/users", gin.H{"email": "x@example.com"}); code != http.StatusBadRequest {
+		t.Error("missing username should be rejected:", code)
+	}
+
+	// 分页
+	code, resp := call(t, r, token, http.MethodGet, "/admin/api/users?page=2&page_size=2", nil)
+	if code != http.StatusOK || resp["total"] != float64(3) || resp["page"] != float64(2) || len(resp["items"].([]interface{})) != 1 {
+		t.Errorf("unexpected page: %d %v", code, resp)
+	}
+	if item := resp["items"].([]interface{})[0].(map[string]interface{}); item["username"] != "carol" {
+		t.Errorf("unexpected item on page 2: %v", item)
+	}
+	if _, resp := call(t, r, token, http.MethodGet, "/admin/api/users?username=bo", nil); resp["total"] != float64(1) {
+		t.Errorf("unexpected filter result: %v", resp)
+	}
+
+	// 更新, 密码为空时不修改, 响应中不返回密码
+	code, resp = call(t, r, token, http.MethodPut, "/admin/api/users/1", gin.H{"username": "alice", "email": "new@example.com", "disabled": true})
+	if code != http.StatusOK || resp["email"] != "new@example.com" || resp["disabled"] != true {
+		t.Errorf("unexpected update: %d %v", code, resp)
+	}
+	if _, ok := resp["password"]; ok {
+		t.Error("password should not be returned")
+	}
+	if u, _ := model.GetUserByID(context.Background(), "1"); u == nil || u.Password == "" {
+		t.Error("password should be kept")
+	}
+	if code, _ := call(t, r, token, http.MethodPut, "/admin/api/users/1", gin.H{"username": "bob"}); code != http.StatusConflict {
+		t.Error("renaming to an existing username should be rejected:", code)
+	}
+
+	if code, _ := call(t, r, token, http.MethodDelete, "/admin/api/users/1", nil); code != http.StatusNoContent {
+		t.Error("delete failed:", code)
+	}
+	if code, _ := call(t, r, token, http.MethodGet, "/admin/api/users/1", nil); code != http.StatusNotFound {
+		t.Error("deleted user should not be found:", code)
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	r := setupAdmin(t)
+	token := adminToken(t, oauth2.ClientCredentials, "ops", "ops_secret", "", "admin")
+
+	// 没有指定 client_id 和 client_secret 时自动生成, secret 只返回一次
+	code, resp := call(t, r, token, http.MethodPost, "/admin/api/clients", gin.H{
+		"client_name":   "new app",
+		"redirect_uris": []string{"https://new.example.com/cb"},
+	})
+	id, _ := resp["client_id"].(string)
+	if code != http.StatusCreated || id == "" || resp["client_secret"] == "" {
+		t.Fatalf("unexpected create: %d %v", code, resp)
+	}
+	code, resp = call(t, r, token, http.MethodGet, "/admin/api/clients/"+id, nil)
+	if code != http.StatusOK || resp["client_name"] != "new app" || resp["client_secret"] != nil {
+		t.Errorf("unexpected get: %d %v", code, resp)
+	}
+
+	if _, resp := call(t, r, token, http.MethodGet, "/admin/api/clients?page_size=2", nil); resp["total"] != float64(3) || len(resp["items"].([]interface{})) != 2 {
+		t.Errorf("unexpected page: %v", resp)
+	}
+	if _, resp := call(t, r, token, http.MethodGet, "/admin/api/clients?client_id=app", nil); resp["total"] != float64(1) {
+		t.Errorf("unexpected filter result: %v", resp)
+	}
+
+	if code, _ := call(t, r, token, http.MethodDelete, "/admin/api/clients/"+id, nil); code != http.StatusNoContent {
+		t.Error("delete failed:", code)
+	}
+	if code, _ := call(t, r, token, http.MethodGet, "/admin/api/clients/"+id, nil); code != http.StatusNotFound {
+		t.Error("deleted client should not be found:", code)
+	}
+}
+
+func TestAdminRevokeOnDisable(t *testing.T) {
+	r := setupAdmin(t)
+	token := adminToken(t, oauth2.ClientCredentials, "ops", "ops_secret", "", "admin")
+	if code, _ := call(t, r, token, http.MethodPost, "/admin/api/users", gin.H{"username": "alice", "password": "pw"}); code != http.StatusCreated {
+		t.Fatal("create user failed:", code)
+	}
+
+	// 禁用用户后, 签发给该用户的 token 立即失效
+	userToken := adminToken(t, oauth2.PasswordCredentials, "app", "app_secret", "1", "profile")
+	code, resp := call(t, r, token, http.MethodPut, "/admin/api/users/1", gin.H{"username": "alice", "disabled": true})
+	if code != http.StatusOK || resp["tokens_revoked"] != nil {
+		t.Fatalf("unexpected disable: %d %v", code, resp)
+	}
+	if _, err := oauth2_val.Mgr.LoadAccessToken(context.Background(), userToken); err == nil {
+		t.Error("token of disabled user should be revoked")
+	}
+}
+
+func TestAdminRevokeUnsupported(t *testing.T) {
+	r := setupAdmin(t)
+	config.GetCfg().OAuth2.TokenStore = "memory"
+	oauth2_val.Setup(context.Background())
+	token := adminToken(t, oauth2.ClientCredentials, "ops", "ops_secret", "", "admin")
+	if code, _ := call(t, r, token, http.MethodPost, "/admin/api/users", gin.H{"username": "alice", "password": "pw"}); code != http.StatusCreated {
+		t.Fatal("create user failed:", code)
+	}
+	userToken := adminToken(t, oauth2.PasswordCredentials, "app", "app_secret", "1", "profile")
+
+	// memory 存储不能撤销 token, 操作本身完成, 但要告知调用方 token 仍然有效
+	code, resp := call(t, r, token, http.MethodPut, "/admin/api/users/1", gin.H{"username": "alice", "disabled": true})
+	if code != http.StatusOK || resp["disabled"] != true || resp["tokens_revoked"] != false || resp["warning"] == "" {
+		t.Errorf("unexpected disable: %d %v", code, resp)
+	}
+	code, resp = call(t, r, token, http.MethodDelete, "/admin/api/users/1", nil)
+	if code != http.StatusOK || resp["tokens_revoked"] != false {
+		t.Errorf("unexpected delete: %d %v", code, resp)
+	}
+	if _, err := model.GetUserByID(context.Background(), "1"); err == nil {
+		t.Error("user should be deleted")
+	}
+	code, resp = call(t, r, token, http.MethodDelete, "/admin/api/clients/app", nil)
+	if code != http.StatusOK || resp["tokens_revoked"] != false {
+		t.Errorf("unexpected client delete: %d %v", code, resp)
+	}
+	if _, err := oauth2_val.Mgr.LoadAccessToken(context.Background(), userToken); err != nil {
+		t.Error("token is still valid on memory store:", err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4/errors"
 )
 
 // getProjectRoot 获取项目根目录（go.mod所在目录）
@@ -91,6 +92,22 @@ func AuthorizeHandler(ctx *gin.Context) {
 	}
 	ctx.Request.Form = form
 
+	// 登录跳转之前按客户端的 redirect_uris 精确校验回调地址
+	if err := oauth2_val.ValidationRedirectURI(ctx.Request); err != nil {
+		if err == errors.ErrInvalidClient {
+			errorHandler(ctx.Writer, "无效的客户端(client_id)", http.StatusBadRequest)
+			return
+		}
+		errorHandler(ctx.Writer, "无效的回调地址(redirect_uri)", http.StatusBadRequest)
+		return
+	}
+
+	// 登录跳转之前检查PKCE参数, 参数会随RequestForm一起保存在session中
+	if err := oauth2_val.ValidationAuthorizePKCE(ctx.Request); err != nil {
+		errorHandler(ctx.Writer, errors.Descriptions[err], http.StatusBadRequest)
+		return
+	}
+
 	if err := session.Delete(ctx.Writer, ctx.Request, "RequestForm"); err != nil {
 		errorHandler(ctx.Writer, err.Error(), http.StatusInternalServerError)
 		return
@@ -107,6 +124,8 @@ type TplData struct {
 	// 用户申请合规的scope
 	Scope []config.Scope
 	Error string
+	// 预先填写的用户名, 来自授权请求的 login_hint
+	LoginHint string
 }
 
 func LoginHandler(ctx *gin.Context) {
@@ -134,16 +153,41 @@ func LoginHandler(ctx *gin.Context) {
 		userID = strconv.Itoa(int(userIDUint))
 		if err != nil {
 			data.Error = err.Error()
+			data.LoginHint = ctx.PostForm("username")
 			renderLoginTemplate(ctx, data)
 			return
 		}
 	}
 	// 可以进行其他方式的验证
+	// 登录前的 session id 可能是别人植入的, 写入登录状态之前更换
+	if err := session.Regenerate(ctx.Writer, ctx.Request); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// 记录登录会话, 用户可以在 /sessions 页面查看和终止
+	if err := session.StartSSO(ctx.Writer, ctx.Request, userID, ctx.ClientIP()); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err := session.Set(ctx.Writer, ctx.Request, "LoggedInUserID", userID); err != nil {
 		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
-	ctx.Redirect(http.StatusFound, "/authorize")
+	// 记录登录时间, 用于 id_token 的 auth_time
+	if err := session.Set(ctx.Writer, ctx.Request, "AuthTime", time.Now().Unix()); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// 从 /device 等页面跳转过来登录的, 登录后回到原来的页面
+	next := "/authorize"
+	if v, _ := session.Get(ctx.Request, "LoginRedirect"); v != nil {
+		next = v.(string)
+		if err := session.Delete(ctx.Writer, ctx.Request, "LoginRedirect"); err != nil {
+			abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	ctx.Redirect(http.StatusFound, next)
 }
 
 func GETloginHandler(ctx *gin.Context) {
@@ -156,8 +200,9 @@ func GETloginHandler(ctx *gin.Context) {
 	scope := form.(url.Values).Get("scope")
 
 	data := TplData{
-		Client: *config.GetOAuth2Client(clientID),
-		Scope:  config.ScopeFilter(clientID, scope),
+		Client:    *config.GetOAuth2Client(clientID),
+		Scope:     config.ScopeFilter(clientID, scope),
+		LoginHint: form.(url.Values).Get("login_hint"),
 	}
 	if data.Scope == nil {
 		abortWithMessage(ctx, http.StatusBadRequest, "无效的权限范围")
@@ -179,8 +224,8 @@ func LogoutHandler(ctx *gin.Context) {
 		return
 	}
 
-	// 删除公共回话
-	if err := session.Delete(ctx.Writer, ctx.Request, "LoggedInUserID"); err != nil {
+	// 删除公共回话和服务端的登录会话
+	if err := session.EndSSO(ctx.Writer, ctx.Request); err != nil {
 		errorHandler(ctx.Writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -190,14 +235,32 @@ func LogoutHandler(ctx *gin.Context) {
 }
 
 func TokenHandler(ctx *gin.Context) {
+	// 设备授权、token exchange 和 jwt-bearer 由 oauth2_val 单独处理, 不经过 Srv
+	switch ctx.PostForm("grant_type") {
+	case oauth2_val.DeviceCodeGrantType:
+		oauth2_val.HandleDeviceTokenRequest(ctx.Writer, ctx.Request)
+		return
+	case oauth2_val.TokenExchangeGrantType:
+		oauth2_val.HandleTokenExchangeRequest(ctx.Writer, ctx.Request)
+		return
+	case oauth2_val.JWTBearerGrantType:
+		oauth2_val.HandleJWTBearerRequest(ctx.Writer, ctx.Request)
+		return
+	}
+	if err := oauth2_val.ValidationTokenPKCE(ctx.Request); err != nil {
+		oauth2_val.WriteTokenError(ctx.Writer, err)
+		return
+	}
 	err := oauth2_val.Srv.HandleTokenRequest(ctx.Writer, ctx.Request)
 	if err != nil {
 		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// VerifyHandler 验证 access token
+// JWT 签名有效之外还要求 token 存储中存在, 已撤销的 token 会被拒绝
 func VerifyHandler(ctx *gin.Context) {
-	token, err := oauth2_val.Srv.ValidationBearerToken(ctx.Request)
+	token, err := oauth2_val.ValidationBearerToken(ctx.Request)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -216,6 +279,43 @@ func VerifyHandler(ctx *gin.Context) {
 	})
 }
 
+// IntrospectHandler token 自省 (RFC 7662)
+// 资源方使用自己的 client 凭证调用, 无效的 token 返回 {"active": false}
+func IntrospectHandler(ctx *gin.Context) {
+	cli, err := oauth2_val.AuthenticateClient(ctx.Request)
+	if err != nil || cli.IsPublic() {
+		oauth2_val.WriteTokenError(ctx.Writer, errors.ErrInvalidClient)
+		return
+	}
+	token := ctx.PostForm("token")
+	if token == "" {
+		oauth2_val.WriteTokenError(ctx.Writer, errors.ErrInvalidRequest)
+		return
+	}
+	data := oauth2_val.Introspect(ctx.Request.Context(), token, ctx.PostForm("token_type_hint"))
+	oauth2_val.WriteToken(ctx.Writer, data, nil, http.StatusOK)
+}
+
+// RevokeHandler 撤销 token (RFC 7009)
+// 公开客户端只需要提供 client_id, 撤销成功或 token 本身无效都返回200
+func RevokeHandler(ctx *gin.Context) {
+	cli, err := oauth2_val.AuthenticateClient(ctx.Request)
+	if err != nil {
+		oauth2_val.WriteTokenError(ctx.Writer, errors.ErrInvalidClient)
+		return
+	}
+	token := ctx.PostForm("token")
+	if token == "" {
+		oauth2_val.WriteTokenError(ctx.Writer, errors.ErrInvalidRequest)
+		return
+	}
+	if err := oauth2_val.RevokeToken(ctx.Request.Context(), cli, token, ctx.PostForm("token_type_hint")); err != nil {
+		oauth2_val.WriteTokenError(ctx.Writer, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
 func NotFoundHandler(ctx *gin.Context) {
 	errorHandler(ctx.Writer, "无效的地址", http.StatusNotFound)
 }
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConsentTplData struct {
+	Client config.OAuth2Client
+	// 申请的scope, 默认全部勾选
+	Scope []config.Scope
+	// 防止跨站提交
+	Token string
+}
+
+// consentRequest 从session中取出授权请求, 用户以当前的登录会话为准
+// 登录会话被终止后不能再保存授权
+func consentRequest(ctx *gin.Context) (form url.Values, userID string, ok bool) {
+	v, _ := session.Get(ctx.Request, "RequestForm")
+	if v == nil {
+		abortWithMessage(ctx, http.StatusBadRequest, "无效的请求")
+		return nil, "", false
+	}
+	sso, ok := currentSSO(ctx)
+	if !ok {
+		return nil, "", false
+	}
+	return v.(url.Values), sso.UserID, true
+}
+
+// ConsentHandler 授权确认页面, 用户可以取消勾选部分scope
+func ConsentHandler(ctx *gin.Context) {
+	form, _, ok := consentRequest(ctx)
+	if !ok {
+		return
+	}
+	clientID := form.Get("client_id")
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil {
+		abortWithMessage(ctx, http.StatusBadRequest, "无效的客户端(client_id)")
+		return
+	}
+	data := ConsentTplData{
+		Client: *cli,
+		Scope:  config.ScopeFilter(clientID, form.Get("scope")),
+		Token:  oauth2_val.RandomToken(),
+	}
+	if err := session.Set(ctx.Writer, ctx.Request, "ConsentToken", data.Token); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t, err := template.ParseFiles(GetTemplatePath("tpl/consent.html"))
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := t.Execute(ctx.Writer, data); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// ConsentSubmitHandler 保存用户的选择并跳回 /authorize
+// 拒绝或一个scope都没有勾选时, 客户端会收到 access_denied
+func ConsentSubmitHandler(ctx *gin.Context) {
+	form, userID, ok := consentRequest(ctx)
+	if !ok {
+		return
+	}
+	token, _ := session.Get(ctx.Request, "ConsentToken")
+	expected, _ := token.(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(ctx.PostForm("token"))) != 1 {
+		abortWithMessage(ctx, http.StatusBadRequest, "无效的请求")
+		return
+	}
+	if err := session.Delete(ctx.Writer, ctx.Request, "ConsentToken"); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	clientID := form.Get("client_id")
+	var requested []string
+	for _, s := range config.ScopeFilter(clientID, form.Get("scope")) {
+		requested = append(requested, s.ID)
+	}
+	approved := ctx.PostFormArray("scope")
+
+	result := "ConsentDenied"
+	if ctx.PostForm("action") == "allow" && (len(approved) > 0 || len(requested) == 0) {
+		if err := oauth2_val.GrantConsent(ctx.Request.Context(), userID, clientID, requested, approved); err != nil {
+			abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		result = "ConsentGranted"
+	}
+	if err := session.Set(ctx.Writer, ctx.Request, result, clientID); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/authorize")
+}
@@ -0,0 +1,84 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/session"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupConsent(t *testing.T) *gin.Engine {
+	r := setupAdmin(t)
+	cfg := config.GetCfg()
+	old := cfg.Session
+	t.Cleanup(func() { cfg.Session = old })
+	cfg.Session.Name = "sid"
+	cfg.Session.SecretKey = "test_secret"
+	cfg.Session.Store = ""
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	session.Setup(ctx)
+	return r
+}
+
+// login 模拟用户登录后停在确认页面, 返回浏览器的 cookie
+func login(t *testing.T, userID string) *http.Cookie {
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	w := httptest.NewRecorder()
+	if err := session.StartSSO(w, r, userID, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]interface{}{
+		"LoggedInUserID": userID,
+		"RequestForm":    url.Values{"client_id": {"app"}, "scope": {"profile"}},
+		"ConsentToken":   "consent_token",
+	} {
+		if err := session.Set(w, r, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cookies := w.Result().Cookies()
+	return cookies[len(cookies)-1]
+}
+
+func submitConsent(r *gin.Engine, cookie *http.Cookie) int {
+	form := url.Values{"token": {"consent_token"}, "action": {"allow"}, "scope": {"profile"}}
+	req := httptest.NewRequest(http.MethodPost, "/consent", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestConsentRequiresSSOSession(t *testing.T) {
+	r := setupConsent(t)
+	ctx := context.Background()
+
+	// 登录会话被终止后, 浏览器中残留的 session 不能再保存授权
+	cookie := login(t, "1")
+	if _, err := model.DeleteSSOSessions(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if code := submitConsent(r, cookie); code != http.StatusUnauthorized {
+		t.Error("terminated session should not submit consent:", code)
+	}
+	if need, _ := oauth2_val.NeedsConsent(ctx, "1", "app", "profile"); !need {
+		t.Error("consent should not be saved")
+	}
+
+	if code := submitConsent(r, login(t, "1")); code != http.StatusFound {
+		t.Fatal("consent submit failed:", code)
+	}
+	if need, _ := oauth2_val.NeedsConsent(ctx, "1", "app", "profile"); need {
+		t.Error("consent should be saved")
+	}
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/session"
+	"oauth2/pkg/storage"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+type DeviceTplData struct {
+	// 为空时显示输入 user_code 的表单
+	UserCode string
+	Client   config.OAuth2Client
+	// 申请的scope, 默认全部勾选
+	Scope []config.Scope
+	// 防止跨站提交
+	Token string
+	Error string
+	// 处理完成后显示的提示
+	Done string
+}
+
+// DeviceAuthorizationHandler 设备申请授权, 返回 device_code 和需要用户输入的 user_code
+func DeviceAuthorizationHandler(ctx *gin.Context) {
+	cli, err := oauth2_val.AuthenticateClient(ctx.Request)
+	if err != nil {
+		oauth2_val.WriteTokenError(ctx.Writer, errors.ErrInvalidClient)
+		return
+	}
+	d, err := oauth2_val.NewDeviceAuthorization(ctx.Request.Context(), cli.GetID(), ctx.PostForm("scope"))
+	if err != nil {
+		oauth2_val.WriteTokenError(ctx.Writer, err)
+		return
+	}
+	uri := strings.TrimRight(config.GetCfg().OAuth2.Issuer, "/") + "/device"
+	userCode := oauth2_val.FormatUserCode(d.UserCode)
+	oauth2_val.WriteToken(ctx.Writer, map[string]interface{}{
+		"device_code":               d.DeviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          uri,
+		"verification_uri_complete": uri + "?user_code=" + url.QueryEscape(userCode),
+		"expires_in":                int64(time.Until(d.ExpiresAt).Seconds()),
+		"interval":                  d.Interval,
+	}, nil, http.StatusOK)
+}
+
+// DeviceHandler 用户输入 user_code 并确认设备申请的权限, 没有登录时先跳转到登录页面
+func DeviceHandler(ctx *gin.Context) {
+	userCode := ctx.Query("user_code")
+	if userCode == "" {
+		// 从登录页面跳回来
+		if v, _ := session.Get(ctx.Request, "DeviceUserCode"); v != nil {
+			userCode = v.(string)
+		}
+	}
+	if userCode == "" {
+		renderDeviceTemplate(ctx, DeviceTplData{})
+		return
+	}
+	d, err := oauth2_val.PendingDevice(ctx.Request.Context(), userCode)
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if d == nil {
+		session.Delete(ctx.Writer, ctx.Request, "DeviceUserCode")
+		renderDeviceTemplate(ctx, DeviceTplData{Error: "无效或已过期的验证码"})
+		return
+	}
+	cli := config.GetOAuth2Client(d.ClientID)
+	if cli == nil {
+		abortWithMessage(ctx, http.StatusBadRequest, "无效的客户端(client_id)")
+		return
+	}
+
+	sso, err := session.CurrentSSO(ctx.Request)
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if sso == nil {
+		// 登录页面需要 RequestForm 显示客户端和scope
+		form := url.Values{"client_id": {d.ClientID}, "scope": {d.Scope}}
+		for k, v := range map[string]interface{}{
+			"RequestForm":    form,
+			"DeviceUserCode": d.UserCode,
+			"LoginRedirect":  "/device",
+		} {
+			if err := session.Set(ctx.Writer, ctx.Request, k, v); err != nil {
+				abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	data := DeviceTplData{
+		UserCode: oauth2_val.FormatUserCode(d.UserCode),
+		Client:   *cli,
+		Scope:    config.ScopeFilter(d.ClientID, d.Scope),
+		Token:    oauth2_val.RandomToken(),
+	}
+	if err := session.Set(ctx.Writer, ctx.Request, "DeviceToken", data.Token); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	renderDeviceTemplate(ctx, data)
+}
+
+// DeviceSubmitHandler 保存用户的选择, 设备下次轮询时拿到结果
+// 拒绝或一个scope都没有勾选时, 设备会收到 access_denied
+func DeviceSubmitHandler(ctx *gin.Context) {
+	token, _ := session.Get(ctx.Request, "DeviceToken")
+	expected, _ := token.(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(ctx.PostForm("token"))) != 1 {
+		abortWithMessage(ctx, http.StatusBadRequest, "无效的请求")
+		return
+	}
+	sso, ok := currentSSO(ctx)
+	if !ok {
+		return
+	}
+	for _, k := range []string{"DeviceToken", "DeviceUserCode", "RequestForm"} {
+		if err := session.Delete(ctx.Writer, ctx.Request, k); err != nil {
+			abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	d, err := oauth2_val.PendingDevice(ctx.Request.Context(), ctx.PostForm("user_code"))
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if d == nil {
+		renderDeviceTemplate(ctx, DeviceTplData{Error: "无效或已过期的验证码"})
+		return
+	}
+	allow := ctx.PostForm("action") == "allow"
+	if err := oauth2_val.CompleteDevice(ctx.Request.Context(), d, sso.UserID, allow, ctx.PostFormArray("scope")); err != nil {
+		if err == oauth2_val.ErrDeviceNotPending {
+			renderDeviceTemplate(ctx, DeviceTplData{Error: "无效或已过期的验证码"})
+			return
+		}
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	done := "已拒绝授权, 可以关闭此页面"
+	if d.Status != storage.DeviceStatusDenied {
+		if err := session.TouchSSO(ctx.Request.Context(), sso, d.ClientID); err != nil {
+			abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		done = "授权成功, 请回到设备上继续操作"
+	}
+	renderDeviceTemplate(ctx, DeviceTplData{Done: done})
+}
+
+func renderDeviceTemplate(ctx *gin.Context, data DeviceTplData) {
+	t, err := template.ParseFiles(GetTemplatePath("tpl/device.html"))
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := t.Execute(ctx.Writer, data); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
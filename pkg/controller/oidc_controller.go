@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserInfoHandler OpenID Connect 的 /userinfo
+// 根据 access token 中的 scope 返回用户信息
+func UserInfoHandler(ctx *gin.Context) {
+	ti, err := oauth2_val.ValidationUserInfoRequest(ctx.Request)
+	if err != nil {
+		bearerError(ctx, err)
+		return
+	}
+	user, err := model.GetUserByID(ctx, ti.GetUserID())
+	if err != nil {
+		bearerError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, oauth2_val.UserInfoClaims(user, ti.GetScope()))
+}
+
+// bearerError 按 RFC 6750 返回 bearer token 的错误
+func bearerError(ctx *gin.Context, err error) {
+	if err == oauth2_val.ErrInsufficientScope {
+		ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_token", "error_description": err.Error()})
+}
+
+// JWKSHandler 公开签名密钥的公钥, 资源方可以用来离线验证 token
+func JWKSHandler(ctx *gin.Context) {
+	set, err := oauth2_val.Keys.JWKs()
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, set)
+}
+
+// DiscoveryHandler OpenID Connect Discovery 文档
+// 这里的地址需要和 router.Setup 中注册的保持一致
+func DiscoveryHandler(ctx *gin.Context) {
+	issuer := config.GetCfg().OAuth2.Issuer
+	base := strings.TrimRight(issuer, "/")
+
+	doc := gin.H{
+		"issuer":                                           issuer,
+		"authorization_endpoint":                           base + "/authorize",
+		"token_endpoint":                                   base + "/token",
+		"userinfo_endpoint":                                base + "/userinfo",
+		"jwks_uri":                                         base + "/.well-known/jwks.json",
+		"introspection_endpoint":                           base + "/introspect",
+		"revocation_endpoint":                              base + "/revoke",
+		"device_authorization_endpoint":                    base + "/device_authorization",
+		"scopes_supported":                                 oauth2_val.ScopesSupported(),
+		"response_types_supported":                         oauth2_val.Srv.Config.AllowedResponseTypes,
+		"grant_types_supported":                            oauth2_val.GrantTypesSupported(),
+		"subject_types_supported":                          []string{"public"},
+		"id_token_signing_alg_values_supported":            []string{oauth2_val.IDTokenSigningAlg()},
+		"token_endpoint_auth_methods_supported":            []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "client_secret_jwt", "none"},
+		"token_endpoint_auth_signing_alg_values_supported": oauth2_val.TokenEndpointAuthSigningAlgs(),
+		"code_challenge_methods_supported":                 oauth2_val.Srv.Config.AllowedCodeChallengeMethods,
+		"prompt_values_supported":                          oauth2_val.PromptValues,
+		"introspection_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "client_secret_jwt"},
+		"revocation_endpoint_auth_methods_supported":       []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "client_secret_jwt", "none"},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "iat", "exp", "auth_time", "nonce",
+			"preferred_username", "picture", "email", "phone_number",
+		},
+	}
+	if oauth2_val.RegistrationEnabled() {
+		doc["registration_endpoint"] = base + "/register"
+	}
+	ctx.JSON(http.StatusOK, doc)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHandler 动态注册客户端 (RFC 7591)
+// 需要在 Authorization 头中携带配置的 initial access token
+func RegisterHandler(ctx *gin.Context) {
+	if !oauth2_val.RegistrationEnabled() {
+		NotFoundHandler(ctx)
+		return
+	}
+	if err := oauth2_val.ValidationInitialAccessToken(ctx.Request); err != nil {
+		bearerError(ctx, err)
+		return
+	}
+
+	md := new(oauth2_val.ClientMetadata)
+	if err := ctx.ShouldBindJSON(md); err != nil {
+		registrationError(ctx, &oauth2_val.RegistrationError{Err: oauth2_val.ErrInvalidClientMetadata, Description: err.Error()})
+		return
+	}
+	c, token, err := oauth2_val.RegisterClient(ctx.Request.Context(), md)
+	if err != nil {
+		registrationError(ctx, err)
+		return
+	}
+	registrationResponse(ctx, http.StatusCreated, c, token)
+}
+
+// GetRegistrationHandler 读取客户端的注册信息 (RFC 7592 2.1)
+func GetRegistrationHandler(ctx *gin.Context) {
+	c, err := oauth2_val.AuthenticateRegistration(ctx.Request, ctx.Param("client_id"))
+	if err != nil {
+		bearerError(ctx, err)
+		return
+	}
+	registrationResponse(ctx, http.StatusOK, c, "")
+}
+
+// UpdateRegistrationHandler 更新客户端的注册信息 (RFC 7592 2.2)
+func UpdateRegistrationHandler(ctx *gin.Context) {
+	c, err := oauth2_val.AuthenticateRegistration(ctx.Request, ctx.Param("client_id"))
+	if err != nil {
+		bearerError(ctx, err)
+		return
+	}
+
+	md := new(oauth2_val.ClientMetadata)
+	if err := ctx.ShouldBindJSON(md); err != nil {
+		registrationError(ctx, &oauth2_val.RegistrationError{Err: oauth2_val.ErrInvalidClientMetadata, Description: err.Error()})
+		return
+	}
+	if err := oauth2_val.UpdateClient(ctx.Request.Context(), c, md); err != nil {
+		registrationError(ctx, err)
+		return
+	}
+	registrationResponse(ctx, http.StatusOK, c, "")
+}
+
+// DeleteRegistrationHandler 删除客户端 (RFC 7592 2.3), 同时撤销签发给它的token
+// 没能撤销token时返回200并说明, 这些token在过期之前仍然有效
+func DeleteRegistrationHandler(ctx *gin.Context) {
+	c, err := oauth2_val.AuthenticateRegistration(ctx.Request, ctx.Param("client_id"))
+	if err != nil {
+		bearerError(ctx, err)
+		return
+	}
+	if err := model.DeleteClient(ctx.Request.Context(), c.ID); err != nil {
+		log.Println("Delete client error:", err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if nr := revokeTokens(ctx, storage.TokenFilter{ClientID: c.ID}); nr != nil {
+		ctx.JSON(http.StatusOK, nr)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func registrationResponse(ctx *gin.Context, status int, c *model.Client, token string) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+	ctx.JSON(status, oauth2_val.ClientRegistrationResponse(c, token))
+}
+
+// registrationError 按 RFC 7591 3.2.2 返回错误
+func registrationError(ctx *gin.Context, err error) {
+	var re *oauth2_val.RegistrationError
+	if errors.As(err, &re) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": re.Err.Error(), "error_description": re.Description})
+		return
+	}
+	log.Println("Client registration error:", err)
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+}
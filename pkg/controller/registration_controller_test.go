@@ -0,0 +1,65 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+func setupRegistration(t *testing.T, tokenStore string) *gin.Engine {
+	r := setupAdmin(t)
+	cfg := config.GetCfg()
+	cfg.OAuth2.TokenStore = tokenStore
+	cfg.OAuth2.Registration = config.Registration{
+		InitialAccessTokens: []string{"initial"},
+		Scope:               []config.Scope{{ID: "profile", Title: "profile"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	oauth2_val.Setup(ctx)
+	return r
+}
+
+// register 动态注册一个客户端, 并为它签发一个 token
+func register(t *testing.T, r *gin.Engine) (clientID, registrationToken, token string) {
+	code, resp := call(t, r, "initial", http.MethodPost, "/register", gin.H{
+		"redirect_uris": []string{"https://app.example.com/cb"},
+		"scope":         "profile",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("register failed: %d %v", code, resp)
+	}
+	clientID, _ = resp["client_id"].(string)
+	secret, _ := resp["client_secret"].(string)
+	registrationToken, _ = resp["registration_access_token"].(string)
+	return clientID, registrationToken, adminToken(t, oauth2.ClientCredentials, clientID, secret, "", "profile")
+}
+
+func TestDeleteRegistration(t *testing.T) {
+	r := setupRegistration(t, "db")
+	clientID, registrationToken, token := register(t, r)
+	if code, resp := call(t, r, registrationToken, http.MethodDelete, "/register/"+clientID, nil); code != http.StatusNoContent {
+		t.Fatalf("delete failed: %d %v", code, resp)
+	}
+	if _, err := oauth2_val.Mgr.LoadAccessToken(context.Background(), token); err == nil {
+		t.Error("token of deleted client should be revoked")
+	}
+}
+
+func TestDeleteRegistrationRevokeUnsupported(t *testing.T) {
+	r := setupRegistration(t, "memory")
+	clientID, registrationToken, _ := register(t, r)
+	// memory 存储不能撤销 token, 客户端照常删除, 但要告知调用方
+	code, resp := call(t, r, registrationToken, http.MethodDelete, "/register/"+clientID, nil)
+	if code != http.StatusOK || resp["tokens_revoked"] != false || resp["warning"] == "" {
+		t.Errorf("unexpected delete: %d %v", code, resp)
+	}
+	if code, _ := call(t, r, registrationToken, http.MethodGet, "/register/"+clientID, nil); code != http.StatusUnauthorized {
+		t.Error("deleted client should not be readable:", code)
+	}
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionsTplData struct {
+	Sessions []model.SSOSession
+	// 当前浏览器的登录会话
+	CurrentID string
+	// 客户端id对应的名称
+	ClientNames map[string]string
+	// 防止跨站提交
+	Token string
+}
+
+// currentSSO 获取当前浏览器的登录会话, 没有登录时显示错误页面
+func currentSSO(ctx *gin.Context) (*model.SSOSession, bool) {
+	s, err := session.CurrentSSO(ctx.Request)
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if s == nil {
+		abortWithMessage(ctx, http.StatusUnauthorized, "请先登录")
+		return nil, false
+	}
+	return s, true
+}
+
+// SessionsHandler 列出当前用户的全部登录会话
+func SessionsHandler(ctx *gin.Context) {
+	current, ok := currentSSO(ctx)
+	if !ok {
+		return
+	}
+	sessions, err := model.ListSSOSessions(ctx.Request.Context(), current.UserID)
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := SessionsTplData{
+		Sessions:    sessions,
+		CurrentID:   current.ID,
+		ClientNames: make(map[string]string),
+		Token:       oauth2_val.RandomToken(),
+	}
+	for _, s := range sessions {
+		for _, id := range s.Clients {
+			if _, ok := data.ClientNames[id]; ok {
+				continue
+			}
+			data.ClientNames[id] = id
+			if cli := config.GetOAuth2Client(id); cli != nil && cli.Name != "" {
+				data.ClientNames[id] = cli.Name
+			}
+		}
+	}
+	if err := session.Set(ctx.Writer, ctx.Request, "SessionsToken", data.Token); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t, err := template.ParseFiles(GetTemplatePath("tpl/sessions.html"))
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := t.Execute(ctx.Writer, data); err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// TerminateSessionHandler 终止当前用户的某个登录会话
+// 终止的是当前浏览器时等同于退出登录
+func TerminateSessionHandler(ctx *gin.Context) {
+	current, ok := currentSSO(ctx)
+	if !ok {
+		return
+	}
+	token, _ := session.Get(ctx.Request, "SessionsToken")
+	expected, _ := token.(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(ctx.PostForm("token"))) != 1 {
+		abortWithMessage(ctx, http.StatusBadRequest, "无效的请求")
+		return
+	}
+
+	id := ctx.PostForm("id")
+	var err error
+	if id == current.ID {
+		err = session.EndSSO(ctx.Writer, ctx.Request)
+	} else {
+		_, err = model.DeleteSSOSession(ctx.Request.Context(), current.UserID, id)
+	}
+	if err != nil {
+		abortWithMessage(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/sessions")
+}
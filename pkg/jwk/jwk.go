@@ -0,0 +1,145 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// ErrUnsupportedKey 不支持的密钥类型
+var ErrUnsupportedKey = errors.New("unsupported key type")
+
+// JWK JSON Web Key (RFC 7517), 只包含公钥部分
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC / OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set JWK Set, 即 jwks_uri 返回的内容
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseSet 解析 JWK Set, 比如客户端注册的 jwks
+func ParseSet(data []byte) (*Set, error) {
+	set := &Set{}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// FormatSet 把 JWK Set 转为 JSON 文本, nil 返回空字符串
+func FormatSet(set *Set) string {
+	if set == nil {
+		return ""
+	}
+	b, _ := json.Marshal(set)
+	return string(b)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// FromPublicKey 把公钥转换为用于签名验证的JWK
+func FromPublicKey(kid, alg string, pub interface{}) (JWK, error) {
+	k := JWK{Kid: kid, Use: "sig", Alg: alg}
+	switch v := pub.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = encode(v.N.Bytes())
+		k.E = encode(big.NewInt(int64(v.E)).Bytes())
+	case *ecdsa.PublicKey:
+		k.Kty = "EC"
+		k.Crv = v.Curve.Params().Name
+		size := (v.Curve.Params().BitSize + 7) / 8
+		k.X = encode(v.X.FillBytes(make([]byte, size)))
+		k.Y = encode(v.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = encode(v)
+	default:
+		return JWK{}, ErrUnsupportedKey
+	}
+	return k, nil
+}
+
+// PublicKey 把JWK转换为公钥, 不支持对称密钥
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.Sign() == 0 || !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, ErrUnsupportedKey
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, ErrUnsupportedKey
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, ErrUnsupportedKey
+		}
+		b, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return ed25519.PublicKey(b), nil
+	}
+	return nil, ErrUnsupportedKey
+}
@@ -0,0 +1,75 @@
+package jwk_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"oauth2/pkg/jwk"
+	"testing"
+)
+
+func TestFromPublicKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	k, err := jwk.FromPublicKey("rsa", "RS256", &rsaKey.PublicKey)
+	if err != nil || k.Kty != "RSA" || k.E != "AQAB" || k.N == "" {
+		t.Error("unexpected rsa jwk:", k, err)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	k, err = jwk.FromPublicKey("ec", "ES256", &ecKey.PublicKey)
+	// P-256 的坐标固定为32字节, base64url后为43个字符
+	if err != nil || k.Kty != "EC" || k.Crv != "P-256" || len(k.X) != 43 || len(k.Y) != 43 {
+		t.Error("unexpected ec jwk:", k, err)
+	}
+
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	k, err = jwk.FromPublicKey("ed", "EdDSA", edPub)
+	if err != nil || k.Kty != "OKP" || k.Crv != "Ed25519" || k.X == "" {
+		t.Error("unexpected ed25519 jwk:", k, err)
+	}
+
+	if _, err := jwk.FromPublicKey("hs", "HS256", []byte("secret")); err != jwk.ErrUnsupportedKey {
+		t.Error("symmetric key should be rejected:", err)
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	var set jwk.Set
+	for _, pub := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey, edPub} {
+		k, err := jwk.FromPublicKey("", "", pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Keys = append(set.Keys, k)
+	}
+	data, _ := json.Marshal(set)
+	parsed, err := jwk.ParseSet(data)
+	if err != nil || len(parsed.Keys) != 3 {
+		t.Fatal("parse jwks failed:", err)
+	}
+
+	// 转换回来的公钥与原来的一致
+	if pub, err := parsed.Keys[0].PublicKey(); err != nil || !rsaKey.PublicKey.Equal(pub) {
+		t.Error("unexpected rsa public key:", err)
+	}
+	if pub, err := parsed.Keys[1].PublicKey(); err != nil || !ecKey.PublicKey.Equal(pub) {
+		t.Error("unexpected ec public key:", err)
+	}
+	if pub, err := parsed.Keys[2].PublicKey(); err != nil || !edPub.Equal(pub) {
+		t.Error("unexpected ed25519 public key:", err)
+	}
+
+	if _, err := (jwk.JWK{Kty: "oct"}).PublicKey(); err != jwk.ErrUnsupportedKey {
+		t.Error("symmetric key should be rejected:", err)
+	}
+	if _, err := (jwk.JWK{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}).PublicKey(); err != jwk.ErrUnsupportedKey {
+		t.Error("point not on curve should be rejected:", err)
+	}
+}
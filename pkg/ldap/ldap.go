@@ -1,6 +1,7 @@
 package ldap
 
 import (
+	"errors"
 	"fmt"
 	ldap "github.com/go-ldap/ldap/v3"
 	"log"
@@ -10,6 +11,29 @@ import (
 	"strings"
 )
 
+var (
+	// ErrInvalidCredentials 用户不存在或者密码错误
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	// ErrNotOpen 还没有调用Open建立连接
+	ErrNotOpen = errors.New("ldap连接未建立")
+)
+
+// 属性映射的默认值
+const (
+	defaultUsernameAttr = "uid"
+	defaultEmailAttr    = "mail"
+	defaultPhoneAttr    = "telephoneNumber"
+)
+
+// Entry 通过LDAP验证的用户信息
+type Entry struct {
+	DN       string
+	Username string
+	Email    string
+	Phone    string
+	Avatar   string
+}
+
 type Session struct {
 	ldapCfg  config.LDAP
 	ldapConn *ldap.Conn
@@ -70,3 +94,99 @@ func (s *Session) Open() error {
 	s.ldapConn = l
 	return nil
 }
+
+// Close 关闭LDAP连接
+func (s *Session) Close() {
+	if s.ldapConn != nil {
+		s.ldapConn.Close()
+		s.ldapConn = nil
+	}
+}
+
+// Authenticate 验证用户名和密码
+// 先使用SearchDN绑定, 在BaseDN下按Filter查找用户,
+// 再使用用户的DN和密码绑定来验证密码
+func (s *Session) Authenticate(username, password string) (*Entry, error) {
+	if s.ldapConn == nil {
+		return nil, ErrNotOpen
+	}
+	// 空密码会被很多服务端当成匿名绑定直接通过
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if !strings.Contains(s.ldapCfg.Filter, "%s") {
+		return nil, fmt.Errorf("illegal ldap filter: %s", s.ldapCfg.Filter)
+	}
+
+	if s.ldapCfg.SearchDN != "" {
+		if err := s.ldapConn.Bind(s.ldapCfg.SearchDN, s.ldapCfg.SearchPassword); err != nil {
+			return nil, fmt.Errorf("ldap search bind failed: %v", err)
+		}
+	}
+
+	attrs := s.attributes()
+	req := ldap.NewSearchRequest(
+		s.ldapCfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, 0, false,
+		fmt.Sprintf(s.ldapCfg.Filter, ldap.EscapeFilter(username)),
+		attrs.list(),
+		nil,
+	)
+	res, err := s.ldapConn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search failed: %v", err)
+	}
+	// 查不到或者查到多个都不能确定是哪个用户
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	e := res.Entries[0]
+
+	if err := s.ldapConn.Bind(e.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	entry := &Entry{
+		DN:       e.DN,
+		Username: e.GetAttributeValue(attrs.Username),
+		Email:    e.GetAttributeValue(attrs.Email),
+		Phone:    e.GetAttributeValue(attrs.Phone),
+	}
+	if attrs.Avatar != "" {
+		entry.Avatar = e.GetAttributeValue(attrs.Avatar)
+	}
+	if entry.Username == "" {
+		entry.Username = username
+	}
+	return entry, nil
+}
+
+type attributes config.LDAPAttributes
+
+// attributes 返回填充默认值之后的属性映射
+func (s *Session) attributes() attributes {
+	a := attributes(s.ldapCfg.Attributes)
+	if a.Username == "" {
+		a.Username = defaultUsernameAttr
+	}
+	if a.Email == "" {
+		a.Email = defaultEmailAttr
+	}
+	if a.Phone == "" {
+		a.Phone = defaultPhoneAttr
+	}
+	return a
+}
+
+func (a attributes) list() []string {
+	l := []string{"dn", a.Username, a.Email, a.Phone}
+	if a.Avatar != "" {
+		l = append(l, a.Avatar)
+	}
+	return l
+}
@@ -0,0 +1,165 @@
+package ldap_test
+
+import (
+	"net"
+	"oauth2/config"
+	"oauth2/pkg/ldap"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+type fakeEntry struct {
+	password string
+	attrs    map[string]string
+}
+
+// fakeServer 进程内的LDAP服务, 只实现了bind/search/unbind
+type fakeServer struct {
+	ln      net.Listener
+	entries map[string]fakeEntry
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		ln: ln,
+		entries: map[string]fakeEntry{
+			"cn=admin,dc=example,dc=com": {password: "admin"},
+			"uid=alice,ou=people,dc=example,dc=com": {
+				password: "alice_pw",
+				attrs: map[string]string{
+					"uid":             "alice",
+					"mail":            "alice@example.com",
+					"telephoneNumber": "123456",
+					"labeledURI":      "http://example.com/alice.png",
+				},
+			},
+		},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			pw := op.Children[2].Data.String()
+			code := uint16(goldap.LDAPResultInvalidCredentials)
+			if e, ok := s.entries[dn]; ok && e.password == pw {
+				code = goldap.LDAPResultSuccess
+			}
+			conn.Write(envelope(id, result(goldap.ApplicationBindResponse, code)))
+		case goldap.ApplicationSearchRequest:
+			filter, _ := goldap.DecompileFilter(op.Children[6])
+			for dn, e := range s.entries {
+				if uid, ok := e.attrs["uid"]; !ok || !strings.Contains(filter, "(uid="+uid+")") {
+					continue
+				}
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				for k, v := range e.attrs {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, ""))
+					vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					attr.AppendChild(vals)
+					attrs.AppendChild(attr)
+				}
+				entry.AppendChild(attrs)
+				conn.Write(envelope(id, entry))
+			}
+			conn.Write(envelope(id, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess)))
+		case goldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func envelope(id int64, op *ber.Packet) []byte {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	p.AppendChild(op)
+	return p.Bytes()
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), ""))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return r
+}
+
+func newSession(t *testing.T, srv *fakeServer) *ldap.Session {
+	s := ldap.NewSession(config.LDAP{
+		URL:            srv.url(),
+		SearchDN:       "cn=admin,dc=example,dc=com",
+		SearchPassword: "admin",
+		BaseDN:         "dc=example,dc=com",
+		Filter:         "(&(uid=%s))",
+		Attributes:     config.LDAPAttributes{Avatar: "labeledURI"},
+	})
+	if err := s.Open(); err != nil {
+		t.Fatal("open ldap session failed:", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv := newFakeServer(t)
+	entry, err := newSession(t, srv).Authenticate("alice", "alice_pw")
+	if err != nil {
+		t.Fatal("authenticate failed:", err)
+	}
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" || entry.Username != "alice" {
+		t.Error("unexpected entry:", entry)
+	}
+	if entry.Email != "alice@example.com" || entry.Phone != "123456" || entry.Avatar != "http://example.com/alice.png" {
+		t.Error("unexpected attributes:", entry)
+	}
+}
+
+func TestAuthenticateInvalidCredentials(t *testing.T) {
+	srv := newFakeServer(t)
+	cases := []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"bob", "alice_pw"},
+		{"*", "alice_pw"},
+	}
+	for _, c := range cases {
+		if _, err := newSession(t, srv).Authenticate(c.username, c.password); err != ldap.ErrInvalidCredentials {
+			t.Errorf("authenticate(%q, %q) = %v, want ErrInvalidCredentials", c.username, c.password, err)
+		}
+	}
+}
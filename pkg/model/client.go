@@ -0,0 +1,212 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Client 注册的OAuth2客户端
+// 实现了 oauth2.ClientInfo, 可以直接交给 go-oauth2 使用
+type Client struct {
+	ID     string `gorm:"primaryKey;size:255" json:"client_id"`
+	Secret string `gorm:"size:255" json:"-"`
+	Name   string `gorm:"size:255" json:"client_name"`
+	Domain string `gorm:"size:255" json:"domain"`
+	// 应用图标和主页, 在登录页面展示
+	LogoURI   string `gorm:"size:1024" json:"logo_uri"`
+	ClientURI string `gorm:"size:1024" json:"client_uri"`
+
+	RedirectURIs    []string       `gorm:"serializer:json" json:"redirect_uris"`
+	Scope           []config.Scope `gorm:"serializer:json" json:"scope"`
+	GrantTypes      []string       `gorm:"serializer:json" json:"grant_types"`
+	LoopbackAnyPort bool           `json:"loopback_any_port"`
+	Public          bool           `json:"public"`
+	RequirePKCE     bool           `gorm:"column:require_pkce" json:"require_pkce"`
+	// client_secret_basic, client_secret_post, private_key_jwt, client_secret_jwt, none
+	TokenEndpointAuthMethod string `gorm:"size:64" json:"token_endpoint_auth_method"`
+	// private_key_jwt 使用的公钥
+	JWKS     *jwk.Set `gorm:"serializer:json" json:"jwks,omitempty"`
+	JWKSFile string   `gorm:"size:1024" json:"jwks_file,omitempty"`
+	// 自己的应用, 不需要用户确认授权
+	FirstParty bool `json:"first_party"`
+	// refresh token 的绝对有效期和滑动有效期, 单位小时
+	RefreshTokenAbsoluteExp int `json:"refresh_token_absolute_exp"`
+	RefreshTokenSlidingExp  int `json:"refresh_token_sliding_exp"`
+	// token exchange 时可以申请的受众
+	TokenExchangeAudiences []string `gorm:"serializer:json" json:"token_exchange_audiences"`
+	// 动态注册的客户端管理自己时使用的 registration_access_token, 只保存SHA-256
+	RegistrationTokenHash string `gorm:"size:64" json:"-"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *Client) TableName() string {
+	return "oauth2_client"
+}
+
+// GetID 实现 oauth2.ClientInfo
+func (c *Client) GetID() string {
+	return c.ID
+}
+
+// GetSecret 实现 oauth2.ClientInfo
+func (c *Client) GetSecret() string {
+	return c.Secret
+}
+
+// GetDomain 实现 oauth2.ClientInfo
+func (c *Client) GetDomain() string {
+	return c.Domain
+}
+
+// IsPublic 实现 oauth2.ClientInfo
+func (c *Client) IsPublic() bool {
+	return c.Public
+}
+
+// GetUserID 实现 oauth2.ClientInfo, 客户端不属于某个用户
+func (c *Client) GetUserID() string {
+	return ""
+}
+
+// ToConfig 转换为配置中的客户端结构, 供 config.GetOAuth2Client 使用
+func (c *Client) ToConfig() *config.OAuth2Client {
+	return &config.OAuth2Client{
+		ID:              c.ID,
+		Secret:          c.Secret,
+		Name:            c.Name,
+		Domain:          c.Domain,
+		Scope:           c.Scope,
+		LogoURI:         c.LogoURI,
+		ClientURI:       c.ClientURI,
+		GrantTypes:      c.GrantTypes,
+		RedirectURIs:    c.RedirectURIs,
+		LoopbackAnyPort: c.LoopbackAnyPort,
+		Public:          c.Public,
+		RequirePKCE:     c.RequirePKCE,
+
+		TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
+		JWKS:                    jwk.FormatSet(c.JWKS),
+		JWKSFile:                c.JWKSFile,
+		FirstParty:              c.FirstParty,
+		RefreshTokenAbsoluteExp: c.RefreshTokenAbsoluteExp,
+		RefreshTokenSlidingExp:  c.RefreshTokenSlidingExp,
+		TokenExchangeAudiences:  c.TokenExchangeAudiences,
+	}
+}
+
+// NewClientFromConfig 根据配置中的客户端创建, jwks 不是有效的 JWK Set 时返回错误
+func NewClientFromConfig(v config.OAuth2Client) (*Client, error) {
+	var set *jwk.Set
+	if v.JWKS != "" {
+		var err error
+		if set, err = jwk.ParseSet([]byte(v.JWKS)); err != nil {
+			return nil, fmt.Errorf("client %s: invalid jwks: %w", v.ID, err)
+		}
+	}
+	return &Client{
+		ID:              v.ID,
+		Secret:          v.Secret,
+		Name:            v.Name,
+		Domain:          v.Domain,
+		LogoURI:         v.LogoURI,
+		ClientURI:       v.ClientURI,
+		RedirectURIs:    v.RedirectURIs,
+		Scope:           v.Scope,
+		GrantTypes:      v.GrantTypes,
+		LoopbackAnyPort: v.LoopbackAnyPort,
+		Public:          v.Public,
+		RequirePKCE:     v.RequirePKCE,
+
+		TokenEndpointAuthMethod: v.TokenEndpointAuthMethod,
+		JWKS:                    set,
+		JWKSFile:                v.JWKSFile,
+		FirstParty:              v.FirstParty,
+		RefreshTokenAbsoluteExp: v.RefreshTokenAbsoluteExp,
+		RefreshTokenSlidingExp:  v.RefreshTokenSlidingExp,
+		TokenExchangeAudiences:  v.TokenExchangeAudiences,
+	}, nil
+}
+
+// GetClientByID 通过clientID获取客户端, 不存在时返回 gorm.ErrRecordNotFound
+func GetClientByID(ctx context.Context, clientID string) (*Client, error) {
+	c := new(Client)
+	if err := GlobalDB.WithContext(ctx).Where("id = ?", clientID).First(c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ClientFilter 查询客户端时的过滤条件, 为空的字段不过滤
+type ClientFilter struct {
+	ID   string
+	Name string
+}
+
+// ListClients 分页查询客户端, limit 为-1时不分页
+// clientID 为精确匹配, 名称为模糊匹配
+func ListClients(ctx context.Context, filter ClientFilter, offset, limit int) (clients []Client, total int64, err error) {
+	db := GlobalDB.WithContext(ctx).Model(&Client{})
+	if filter.ID != "" {
+		db = db.Where("id = ?", filter.ID)
+	}
+	if filter.Name != "" {
+		db = db.Where("name LIKE ?", "%"+filter.Name+"%")
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("id").Offset(offset).Limit(limit).Find(&clients).Error
+	return
+}
+
+// SaveClient 创建或更新客户端
+func SaveClient(ctx context.Context, c *Client) error {
+	return GlobalDB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(c).Error
+}
+
+// DeleteClient 删除客户端及用户对它的授权记录
+func DeleteClient(ctx context.Context, clientID string) error {
+	return GlobalDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("client_id = ?", clientID).Delete(&Consent{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", clientID).Delete(&Client{}).Error
+	})
+}
+
+// SeedClients 把配置文件中的客户端同步到数据库
+// 配置文件中的客户端以配置为准, 数据库中其他的客户端不受影响
+func SeedClients(ctx context.Context, clients []config.OAuth2Client) error {
+	for _, v := range clients {
+		c, err := NewClientFromConfig(v)
+		if err != nil {
+			return err
+		}
+		if err := SaveClient(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadClient 供 config.GetOAuth2Client 从数据库读取客户端
+func loadClient(clientID string) *config.OAuth2Client {
+	c, err := GetClientByID(context.Background(), clientID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("Load client error:", err)
+		}
+		return nil
+	}
+	return c.ToConfig()
+}
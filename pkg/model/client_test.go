@@ -0,0 +1,90 @@
+package model_test
+
+import (
+	"context"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(model.User{}, model.Client{}, model.Consent{}, model.SSOSession{}); err != nil {
+		t.Fatal(err)
+	}
+	model.GlobalDB = db
+	t.Cleanup(func() { model.GlobalDB = nil })
+}
+
+func TestSeedClients(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+
+	clients := []config.OAuth2Client{{
+		ID:           "app_1",
+		Secret:       "secret_1",
+		Name:         "app1",
+		RedirectURIs: []string{"http://localhost:9093/cb"},
+		Scope:        []config.Scope{{ID: "openid", Title: "openid"}},
+		GrantTypes:   []string{"authorization_code"},
+	}}
+	if err := model.SeedClients(ctx, clients); err != nil {
+		t.Fatal(err)
+	}
+
+	// 数据库中单独注册的客户端
+	if err := model.SaveClient(ctx, &model.Client{ID: "app_2", Public: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 再次同步时以配置为准
+	clients[0].Secret = "secret_2"
+	if err := model.SeedClients(ctx, clients); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := model.GetClientByID(ctx, "app_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetSecret() != "secret_2" || len(c.RedirectURIs) != 1 || len(c.Scope) != 1 || c.Scope[0].ID != "openid" {
+		t.Error("unexpected client:", c)
+	}
+	cfg := c.ToConfig()
+	if !cfg.AllowsGrantType("authorization_code") || cfg.AllowsGrantType("password") {
+		t.Error("unexpected grant types:", cfg.GrantTypes)
+	}
+
+	// 配置中的 jwks 为 JSON 文本, 保存时解析
+	clients[0].JWKS = `{"keys":[{"kty":"EC","kid":"k1","crv":"P-256","x":"x","y":"y"}]}`
+	if err := model.SeedClients(ctx, clients); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := model.GetClientByID(ctx, "app_1"); err != nil || c.JWKS == nil || len(c.JWKS.Keys) != 1 || c.ToConfig().JWKS != clients[0].JWKS {
+		t.Error("unexpected jwks:", c, err)
+	}
+	clients[0].JWKS = "{"
+	if err := model.SeedClients(ctx, clients); err == nil {
+		t.Error("invalid jwks should be rejected")
+	}
+	clients[0].JWKS = ""
+	if err := model.SeedClients(ctx, clients); err != nil {
+		t.Fatal(err)
+	}
+
+	list, total, err := model.ListClients(ctx, model.ClientFilter{}, 0, -1)
+	if err != nil || total != 2 || len(list) != 2 || !list[1].IsPublic() {
+		t.Error("unexpected clients:", list, err)
+	}
+	list, total, err = model.ListClients(ctx, model.ClientFilter{Name: "app"}, 0, 10)
+	if err != nil || total != 1 || list[0].ID != "app_1" {
+		t.Error("unexpected filtered clients:", list, err)
+	}
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Consent 用户对客户端的授权记录
+// 每个用户和客户端只有一条, 记录用户同意的scope
+type Consent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"size:64;uniqueIndex:idx_consent_user_client" json:"user_id"`
+	ClientID  string    `gorm:"size:255;uniqueIndex:idx_consent_user_client;index" json:"client_id"`
+	Scope     []string  `gorm:"serializer:json" json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *Consent) TableName() string {
+	return "oauth2_consent"
+}
+
+// Covers 授权未过期且包含全部scope
+func (c *Consent) Covers(scope []string) bool {
+	if c == nil || time.Now().After(c.ExpiresAt) {
+		return false
+	}
+	for _, s := range scope {
+		if !c.Has(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Has 是否同意了该scope, 不检查过期
+func (c *Consent) Has(scope string) bool {
+	for _, v := range c.Scope {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConsent 获取用户对客户端的授权, 不存在或已过期时返回nil
+func GetConsent(ctx context.Context, userID, clientID string) (*Consent, error) {
+	c := new(Consent)
+	err := GlobalDB.WithContext(ctx).Where("user_id = ? AND client_id = ?", userID, clientID).First(c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// SaveConsent 保存用户对客户端的授权, 已存在时覆盖
+func SaveConsent(ctx context.Context, c *Consent) error {
+	return GlobalDB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "expires_at", "updated_at"}),
+	}).Create(c).Error
+}
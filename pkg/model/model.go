@@ -1,11 +1,14 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"oauth2/config"
 	"time"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -14,10 +17,15 @@ var GlobalDB *gorm.DB
 
 func Setup() {
 	GlobalDB = DB()
-	err := GlobalDB.AutoMigrate(User{})
+	err := GlobalDB.AutoMigrate(User{}, Client{}, Consent{}, SSOSession{})
 	if err != nil {
 		panic(err)
 	}
+	// 配置文件中的客户端同步到数据库, 之后统一从数据库读取
+	if err := SeedClients(context.Background(), config.GetCfg().OAuth2.Client); err != nil {
+		panic(err)
+	}
+	config.SetClientLoader(loadClient)
 }
 
 func DB() *gorm.DB {
@@ -26,6 +34,9 @@ func DB() *gorm.DB {
 	}
 	var err error
 	cfg := config.GetCfg().DB.Default
+	gormCfg := &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+	}
 
 	switch cfg.Type {
 	case "mysql":
@@ -37,11 +48,25 @@ func DB() *gorm.DB {
 			cfg.Port,
 			cfg.DBName)
 		fmt.Println(dsn)
-		GlobalDB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-			Logger: logger.Default.LogMode(logger.Info),
-		})
+		GlobalDB, err = gorm.Open(mysql.Open(dsn), gormCfg)
 	case "postgres":
-
+		sslMode := cfg.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC",
+			cfg.Host,
+			cfg.Port,
+			cfg.UserName,
+			cfg.Password,
+			cfg.DBName,
+			sslMode)
+		GlobalDB, err = gorm.Open(postgres.Open(dsn), gormCfg)
+	case "sqlite":
+		// dbname 为数据库文件路径, 使用纯Go实现的驱动, 不依赖cgo
+		GlobalDB, err = gorm.Open(sqlite.Open(cfg.DBName), gormCfg)
+	default:
+		err = fmt.Errorf("unsupported db type %q", cfg.Type)
 	}
 	if err != nil {
 		panic(err)
@@ -52,6 +77,10 @@ func DB() *gorm.DB {
 	}
 	sqlDb.SetMaxIdleConns(10)
 	sqlDb.SetMaxOpenConns(100)
+	if cfg.Type == "sqlite" {
+		// SQLite 同时只能有一个写入者, 多个连接并发写入会报 database is locked
+		sqlDb.SetMaxOpenConns(1)
+	}
 	sqlDb.SetConnMaxLifetime(time.Hour)
 	return GlobalDB
 }
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"context"
+	"oauth2/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var GlobalRedis *redis.Client
+
+// Redis 获取默认的redis连接, 第一次调用时创建
+func Redis() *redis.Client {
+	if GlobalRedis != nil {
+		return GlobalRedis
+	}
+	cfg := config.GetCfg().Redis.Default
+	GlobalRedis = redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	if err := GlobalRedis.Ping(context.Background()).Err(); err != nil {
+		panic(err)
+	}
+	return GlobalRedis
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SSOSession 用户在浏览器中的一次登录
+// 浏览器的session中只记录id, 删除记录后该浏览器需要重新登录
+type SSOSession struct {
+	ID        string `gorm:"primaryKey;size:64" json:"id"`
+	UserID    string `gorm:"size:64;index" json:"user_id"`
+	IP        string `gorm:"size:64" json:"ip"`
+	UserAgent string `gorm:"size:512" json:"user_agent"`
+	// 通过这次登录授权过的客户端
+	Clients      []string  `gorm:"serializer:json" json:"clients"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+}
+
+func (s *SSOSession) TableName() string {
+	return "oauth2_sso_session"
+}
+
+// GetSSOSession 获取登录会话, 不存在或已过期时返回nil
+func GetSSOSession(ctx context.Context, id string) (*SSOSession, error) {
+	s := new(SSOSession)
+	// 时间统一使用UTC, sqlite 按字符串比较时间
+	err := GlobalDB.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now().UTC()).First(s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListSSOSessions 列出用户未过期的登录会话, 最近活动的在前
+func ListSSOSessions(ctx context.Context, userID string) (sessions []SSOSession, err error) {
+	err = GlobalDB.WithContext(ctx).Where("user_id = ? AND expires_at > ?", userID, time.Now().UTC()).
+		Order("last_active_at DESC").Find(&sessions).Error
+	return
+}
+
+// SaveSSOSession 创建或更新登录会话
+func SaveSSOSession(ctx context.Context, s *SSOSession) error {
+	return GlobalDB.WithContext(ctx).Save(s).Error
+}
+
+// DeleteSSOSession 删除用户的某个登录会话, 不存在时返回false
+func DeleteSSOSession(ctx context.Context, userID, id string) (bool, error) {
+	result := GlobalDB.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&SSOSession{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteSSOSessions 删除用户的全部登录会话, 返回删除的数量
+func DeleteSSOSessions(ctx context.Context, userID string) (int64, error) {
+	result := GlobalDB.WithContext(ctx).Where("user_id = ?", userID).Delete(&SSOSession{})
+	return result.RowsAffected, result.Error
+}
+
+// CleanupSSOSessions 删除过期的登录会话
+func CleanupSSOSessions(ctx context.Context) error {
+	return GlobalDB.WithContext(ctx).Where("expires_at <= ?", time.Now().UTC()).Delete(&SSOSession{}).Error
+}
@@ -0,0 +1,59 @@
+package model_test
+
+import (
+	"context"
+	"oauth2/pkg/model"
+	"testing"
+	"time"
+)
+
+func TestSSOSession(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	sessions := []*model.SSOSession{
+		{ID: "s1", UserID: "1", Clients: []string{"app_1"}, LastActiveAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: "s2", UserID: "1", LastActiveAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "s3", UserID: "1", LastActiveAt: now, ExpiresAt: now.Add(-time.Second)},
+		{ID: "s4", UserID: "2", LastActiveAt: now, ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, s := range sessions {
+		if err := model.SaveSSOSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := model.GetSSOSession(ctx, "s1")
+	if err != nil || s == nil || len(s.Clients) != 1 || s.Clients[0] != "app_1" {
+		t.Fatal("unexpected session:", s, err)
+	}
+	// 过期的会话
+	if s, err := model.GetSSOSession(ctx, "s3"); err != nil || s != nil {
+		t.Fatal("expired session returned:", s, err)
+	}
+
+	list, err := model.ListSSOSessions(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != "s2" || list[1].ID != "s1" {
+		t.Error("unexpected sessions:", list)
+	}
+
+	// 不能删除其他用户的会话
+	if ok, err := model.DeleteSSOSession(ctx, "1", "s4"); err != nil || ok {
+		t.Error("deleted session of another user:", ok, err)
+	}
+	if ok, err := model.DeleteSSOSession(ctx, "1", "s1"); err != nil || !ok {
+		t.Error("delete session failed:", ok, err)
+	}
+
+	n, err := model.DeleteSSOSessions(ctx, "1")
+	if err != nil || n != 2 {
+		t.Error("unexpected deleted count:", n, err)
+	}
+	if s, _ := model.GetSSOSession(ctx, "s4"); s == nil {
+		t.Error("session of another user deleted")
+	}
+}
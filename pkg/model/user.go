@@ -2,33 +2,242 @@ package model
 
 import (
 	"context"
+	"errors"
+	"log"
 	"oauth2/config"
+	"oauth2/pkg/ldap"
+	"oauth2/pkg/password"
+	"sync"
+
+	"gorm.io/gorm"
 )
 
+// ErrInvalidCredentials 用户不存在或者密码错误
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// ErrUserDisabled 用户已被禁用
+var ErrUserDisabled = errors.New("用户已被禁用")
+
+// ErrDuplicateEmail 多个用户使用同一个邮箱, 无法确定是哪一个
+var ErrDuplicateEmail = errors.New("邮箱对应多个用户")
+
+// dummyHashes 用户不存在时也做一次哈希比较, 避免通过响应时间判断用户是否存在
+// 按密码配置缓存, 与真实用户的哈希使用相同的算法和参数
+var dummyHashes sync.Map
+
+// dummyHash 返回当前密码配置对应的哈希, 生成失败时使用默认参数的 bcrypt
+func dummyHash(cfg config.Password, hasher *password.Hasher) string {
+	if v, ok := dummyHashes.Load(cfg); ok {
+		return v.(string)
+	}
+	hash, err := hasher.Hash("dummy_password")
+	if err != nil {
+		return "$2a$10$VcTJdlVVaDwZznYzLi7RbuycVYCF9O.IL6HWU1.9K7jXjuqq8YKbS"
+	}
+	dummyHashes.Store(cfg, hash)
+	return hash
+}
+
 type User struct {
 	ID       uint   `gorm:"primary_key" json:"id"`
 	Username string `gorm:"size:255" json:"username"`
-	Password string `gorm:"size:255" json:"password"`
+	Password string `gorm:"size:255" json:"-"`
 	Avatar   string `json:"avatar"`
 	Email    string `json:"email"`
 	Phone    string `json:"phone"`
+	// 被禁用的用户不能登录
+	Disabled bool `json:"disabled"`
 }
 
 func (u *User) TableName() string {
 	return "user"
 }
 
+// GetUserByID 通过用户ID获取用户, userID为token中的字符串形式
+func GetUserByID(ctx context.Context, userID string) (*User, error) {
+	u := new(User)
+	if err := GlobalDB.WithContext(ctx).Where("id = ?", userID).First(u).Error; err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func (u *User) Authentication(ctx context.Context, clientID, username, password string) (userID uint, err error) {
 	if config.GetCfg().AuthMode == "db" {
-		u := new(User)
-		if err := GlobalDB.WithContext(ctx).Where("username = ? AND password = ?", username, password).First(u).Error; err != nil {
-			return 0, err
+		return dbAuthentication(ctx, username, password)
+	}
+	if config.GetCfg().AuthMode == "ldap" {
+		return ldapAuthentication(ctx, username, password)
+	}
+	return
+}
+
+// UserFilter 查询用户时的过滤条件, 为空的字段不过滤
+type UserFilter struct {
+	Username string
+	Email    string
+}
+
+// ListUsers 分页查询用户, 用户名和邮箱为模糊匹配
+func ListUsers(ctx context.Context, filter UserFilter, offset, limit int) (users []User, total int64, err error) {
+	db := GlobalDB.WithContext(ctx).Model(&User{})
+	if filter.Username != "" {
+		db = db.Where("username LIKE ?", "%"+filter.Username+"%")
+	}
+	if filter.Email != "" {
+		db = db.Where("email LIKE ?", "%"+filter.Email+"%")
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("id").Offset(offset).Limit(limit).Find(&users).Error
+	return
+}
+
+// GetUserByUsername 通过用户名获取用户
+func GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	u := new(User)
+	if err := GlobalDB.WithContext(ctx).Where("username = ?", username).First(u).Error; err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetUserByEmail 通过邮箱获取用户, 邮箱不唯一时返回 ErrDuplicateEmail
+func GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	var users []User
+	if err := GlobalDB.WithContext(ctx).Where("email = ?", email).Limit(2).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	switch len(users) {
+	case 0:
+		return nil, gorm.ErrRecordNotFound
+	case 1:
+		return &users[0], nil
+	}
+	return nil, ErrDuplicateEmail
+}
+
+// SetPassword 按配置的算法哈希后设置密码
+func (u *User) SetPassword(plain string) error {
+	hash, err := password.New(config.GetCfg().Password).Hash(plain)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return nil
+}
+
+// SaveUser 创建或更新用户
+func SaveUser(ctx context.Context, u *User) error {
+	return GlobalDB.WithContext(ctx).Save(u).Error
+}
+
+// DeleteUser 删除用户及其授权记录和登录会话
+func DeleteUser(ctx context.Context, userID string) error {
+	return GlobalDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&Consent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&SSOSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", userID).Delete(&User{}).Error
+	})
+}
+
+// dbAuthentication 通过user表验证用户
+// 密码在程序中以常量时间比较, 验证通过后把明文或参数过时的哈希升级为当前配置的哈希
+func dbAuthentication(ctx context.Context, username, plain string) (userID uint, err error) {
+	cfg := config.GetCfg().Password
+	hasher := password.New(cfg)
+	u := new(User)
+	if err = GlobalDB.WithContext(ctx).Where("username = ?", username).First(u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hasher.Verify(dummyHash(cfg, hasher), plain)
+			return 0, ErrInvalidCredentials
 		}
-		userID = u.ID
 		return
 	}
-	if config.GetCfg().AuthMode == "ldap" {
 
+	ok, needsRehash := hasher.Verify(u.Password, plain)
+	if !ok {
+		return 0, ErrInvalidCredentials
+	}
+	if u.Disabled {
+		return 0, ErrUserDisabled
+	}
+	if needsRehash {
+		// 升级失败不影响本次登录, 下次登录时会再次尝试
+		if hash, err := hasher.Hash(plain); err != nil {
+			log.Println("Rehash password error:", err)
+		} else if err := GlobalDB.WithContext(ctx).Model(u).Update("password", hash).Error; err != nil {
+			log.Println("Rehash password error:", err)
+		}
+	}
+	return u.ID, nil
+}
+
+// MigratePlaintextPasswords 把user表中的明文密码全部哈希
+// 用于长期不登录、无法在登录时自动升级的用户, 返回迁移的数量
+func MigratePlaintextPasswords(ctx context.Context) (count int, err error) {
+	hasher := password.New(config.GetCfg().Password)
+	var users []User
+	err = GlobalDB.WithContext(ctx).Where("password <> ''").FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+		for _, u := range users {
+			if password.IsHashed(u.Password) {
+				continue
+			}
+			hash, err := hasher.Hash(u.Password)
+			if err != nil {
+				return err
+			}
+			if err := GlobalDB.WithContext(ctx).Model(&User{}).Where("id = ?", u.ID).Update("password", hash).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}).Error
+	return
+}
+
+// ldapAuthentication 通过LDAP验证用户
+// 验证通过后把用户信息同步到user表, 使用user表的ID作为稳定的用户ID
+func ldapAuthentication(ctx context.Context, username, password string) (userID uint, err error) {
+	s := ldap.NewSession(config.GetCfg().LDAP)
+	if err = s.Open(); err != nil {
+		return
+	}
+	defer s.Close()
+
+	entry, err := s.Authenticate(username, password)
+	if err != nil {
+		return
+	}
+
+	u := new(User)
+	err = GlobalDB.WithContext(ctx).
+		Where("username = ?", entry.Username).
+		Attrs(User{Email: entry.Email, Phone: entry.Phone, Avatar: entry.Avatar}).
+		FirstOrCreate(u).Error
+	if err != nil {
+		return
+	}
+	if u.Disabled {
+		return 0, ErrUserDisabled
+	}
+	// LDAP中的信息有变化时同步过来
+	if u.Email != entry.Email || u.Phone != entry.Phone || u.Avatar != entry.Avatar {
+		err = GlobalDB.WithContext(ctx).Model(u).Updates(map[string]interface{}{
+			"email":  entry.Email,
+			"phone":  entry.Phone,
+			"avatar": entry.Avatar,
+		}).Error
+		if err != nil {
+			return
+		}
 	}
+	userID = u.ID
 	return
 }
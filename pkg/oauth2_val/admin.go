@@ -0,0 +1,113 @@
+package oauth2_val
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/storage"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// ScopeAdmin 访问管理接口需要的scope
+const ScopeAdmin = "admin"
+
+// ErrTokenListUnsupported 当前的token存储不支持列出token
+var ErrTokenListUnsupported = errors.New("token store does not support listing")
+
+// ValidationAdminRequest 验证管理接口请求携带的access token, 必须包含 admin scope
+// 只接受 client_credentials 签发的 token, 代表用户的 token 即使有 admin scope 也不能使用
+func ValidationAdminRequest(r *http.Request) (oauth2.TokenInfo, error) {
+	ti, err := ValidationBearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if !config.HasScope(ti.GetScope(), ScopeAdmin) || ti.GetUserID() != "" {
+		return nil, ErrInsufficientScope
+	}
+	return ti, nil
+}
+
+// ListTokens 按客户端或用户分页列出有效的token
+func ListTokens(ctx context.Context, filter storage.TokenFilter, offset, limit int) ([]oauth2.TokenInfo, int64, error) {
+	lister, ok := TokenStore.(storage.TokenLister)
+	if !ok {
+		return nil, 0, ErrTokenListUnsupported
+	}
+	return lister.ListTokens(ctx, filter, offset, limit)
+}
+
+// RevokeTokens 撤销客户端或用户的全部token, 返回撤销的数量
+func RevokeTokens(ctx context.Context, filter storage.TokenFilter) (int, error) {
+	const batch = 100
+	count := 0
+	var last int64 = -1
+	for {
+		tokens, total, err := ListTokens(ctx, filter, 0, batch)
+		if err != nil {
+			return count, err
+		}
+		// 没有剩余的token, 或者删除没有生效时结束, 避免死循环
+		if len(tokens) == 0 || total == last {
+			return count, nil
+		}
+		last = total
+		for _, ti := range tokens {
+			tokenType := "access_token"
+			if ti.GetRefresh() != "" {
+				tokenType = "refresh_token"
+			}
+			if err := removeToken(ctx, ti, tokenType); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+}
+
+// AdminRevokeToken 撤销指定的token, 不检查token属于哪个客户端
+// token 不存在时返回false
+func AdminRevokeToken(ctx context.Context, token string) (bool, error) {
+	ti, tokenType := LoadToken(ctx, token, "")
+	if ti == nil {
+		return false, nil
+	}
+	return true, removeToken(ctx, ti, tokenType)
+}
+
+// supportedGrantTypes 管理接口可以为客户端配置的授权方式
+var supportedGrantTypes = []string{"authorization_code", "implicit", "password", "client_credentials", "refresh_token", DeviceCodeGrantType, TokenExchangeGrantType, JWTBearerGrantType}
+
+// ValidateClient 校验管理接口提交的客户端
+// 管理员是可信的, 回调地址只要求是不带fragment的绝对地址
+func ValidateClient(c *model.Client) error {
+	if c.TokenEndpointAuthMethod != "" && !contains(registrableAuthMethods, c.TokenEndpointAuthMethod) {
+		return metadataError(ErrInvalidClientMetadata, "unsupported token_endpoint_auth_method "+c.TokenEndpointAuthMethod)
+	}
+	for _, v := range c.GrantTypes {
+		if !contains(supportedGrantTypes, v) {
+			return metadataError(ErrInvalidClientMetadata, "unsupported grant_type "+v)
+		}
+	}
+	if c.Public && contains(c.GrantTypes, "client_credentials") {
+		return metadataError(ErrInvalidClientMetadata, "client_credentials requires client authentication")
+	}
+	if err := validateClientJWTAuth(c.ToConfig()); err != nil {
+		return err
+	}
+	if c.Public && len(c.TokenExchangeAudiences) > 0 {
+		return metadataError(ErrInvalidClientMetadata, "token exchange requires client authentication")
+	}
+	for _, v := range c.RedirectURIs {
+		if u, err := url.Parse(v); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return metadataError(ErrInvalidRedirectURI, "invalid redirect_uri "+v)
+		}
+	}
+	if c.RefreshTokenAbsoluteExp < 0 || c.RefreshTokenSlidingExp < 0 {
+		return metadataError(ErrInvalidClientMetadata, "refresh token lifetime must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package oauth2_val
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"oauth2/pkg/storage"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT 客户端认证方式 (RFC 7523, OpenID Connect Core 9)
+const (
+	AuthMethodPrivateKeyJWT   = "private_key_jwt"
+	AuthMethodClientSecretJWT = "client_secret_jwt"
+)
+
+// ClientAssertionType client_assertion_type 的固定值
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// assertionMaxAge client assertion 和 jwt-bearer assertion 的 exp 最多比当前时间晚这么久
+// jti 需要保存到 exp, 限制有效期避免记录过多
+const assertionMaxAge = time.Hour
+
+// 可以用于 client assertion 的签名算法
+var (
+	privateKeyJWTAlgs   = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	clientSecretJWTAlgs = []string{"HS256", "HS384", "HS512"}
+)
+
+// TokenEndpointAuthSigningAlgs client assertion 可以使用的签名算法, 用于 discovery
+func TokenEndpointAuthSigningAlgs() []string {
+	return append(append([]string{}, privateKeyJWTAlgs...), clientSecretJWTAlgs...)
+}
+
+// jtiStore 与当前 token 存储对应的已使用 jti 记录
+var jtiStore storage.JTIStore
+
+// isJWTAuthMethod 使用 client assertion 认证的客户端
+func isJWTAuthMethod(method string) bool {
+	return method == AuthMethodPrivateKeyJWT || method == AuthMethodClientSecretJWT
+}
+
+// ClientJWKS 客户端的公钥, 配置了 jwks_file 时每次从文件读取, 方便更换密钥
+func ClientJWKS(cli *config.OAuth2Client) (*jwk.Set, error) {
+	return loadJWKS(cli.JWKS, cli.JWKSFile)
+}
+
+// loadJWKS 配置了文件时从文件读取, 否则解析直接配置的 JWK Set
+func loadJWKS(set, file string) (*jwk.Set, error) {
+	if file == "" {
+		if set == "" {
+			return &jwk.Set{}, nil
+		}
+		return jwk.ParseSet([]byte(set))
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.ParseSet(data)
+}
+
+// validateClientJWTAuth 校验 JWT 认证方式需要的配置
+// private_key_jwt 至少需要一个可以使用的公钥, 公开客户端不能使用 JWT 认证
+func validateClientJWTAuth(cli *config.OAuth2Client) error {
+	if !isJWTAuthMethod(cli.TokenEndpointAuthMethod) {
+		if cli.JWKS != "" || cli.JWKSFile != "" {
+			return metadataError(ErrInvalidClientMetadata, "jwks requires token_endpoint_auth_method private_key_jwt")
+		}
+		return nil
+	}
+	if cli.Public {
+		return metadataError(ErrInvalidClientMetadata, cli.TokenEndpointAuthMethod+" requires a confidential client")
+	}
+	if cli.TokenEndpointAuthMethod == AuthMethodClientSecretJWT {
+		return nil
+	}
+	if cli.JWKS != "" && cli.JWKSFile != "" {
+		return metadataError(ErrInvalidClientMetadata, "jwks and jwks_file must not both be present")
+	}
+	set, err := ClientJWKS(cli)
+	if err != nil {
+		return metadataError(ErrInvalidClientMetadata, "invalid jwks: "+err.Error())
+	}
+	for _, k := range set.Keys {
+		if _, err := k.PublicKey(); err != nil {
+			return metadataError(ErrInvalidClientMetadata, "unsupported key in jwks "+k.Kid)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return metadataError(ErrInvalidClientMetadata, "private_key_jwt requires jwks")
+	}
+	return nil
+}
+
+// requestClientID 请求中声明的 client_id, 不做验证
+func requestClientID(r *http.Request) string {
+	if r.PostFormValue("client_assertion") != "" {
+		claims := &jwt.RegisteredClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(r.PostFormValue("client_assertion"), claims); err == nil {
+			return claims.Subject
+		}
+		return ""
+	}
+	if clientID, _, ok := r.BasicAuth(); ok {
+		return clientID
+	}
+	return r.FormValue("client_id")
+}
+
+// clientAssertionHandler 验证 client_assertion, 成功时返回客户端保存的 secret
+// 之后 Mgr 和 AuthenticateClient 比较 secret 时可以通过
+func clientAssertionHandler(r *http.Request) (clientID, clientSecret string, err error) {
+	if r.PostFormValue("client_assertion_type") != ClientAssertionType {
+		return "", "", errors.ErrInvalidClient
+	}
+	assertion := r.PostFormValue("client_assertion")
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, claims); err != nil {
+		return "", "", errors.ErrInvalidClient
+	}
+	// iss 和 sub 都是 client_id, 表单中有 client_id 时必须一致
+	clientID = claims.Subject
+	if v := r.PostFormValue("client_id"); v != "" && v != clientID {
+		return "", "", errors.ErrInvalidClient
+	}
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil || !isJWTAuthMethod(cli.TokenEndpointAuthMethod) {
+		return "", "", errors.ErrInvalidClient
+	}
+
+	var algs []string
+	var keyFunc jwt.Keyfunc
+	if cli.TokenEndpointAuthMethod == AuthMethodClientSecretJWT {
+		if cli.Secret == "" {
+			return "", "", errors.ErrInvalidClient
+		}
+		algs = clientSecretJWTAlgs
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			return []byte(cli.Secret), nil
+		}
+	} else {
+		set, err := ClientJWKS(cli)
+		if err != nil {
+			return "", "", errors.ErrServerError
+		}
+		algs = privateKeyJWTAlgs
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			return jwksVerifyKeys(set, token)
+		}
+	}
+	claims = &jwt.RegisteredClaims{}
+	_, err = jwt.NewParser(
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+		jwt.WithExpirationRequired(),
+	).ParseWithClaims(assertion, claims, keyFunc)
+	if err != nil || !validAssertionAudience(r, claims.Audience) {
+		return "", "", errors.ErrInvalidClient
+	}
+	if claims.ID == "" || time.Until(claims.ExpiresAt.Time) > assertionMaxAge {
+		return "", "", errors.ErrInvalidClient
+	}
+
+	// 同一个 assertion 只能使用一次
+	sum := sha256.Sum256([]byte(clientID + ":" + claims.ID))
+	ok, err := jtiStore.UseJTI(r.Context(), hex.EncodeToString(sum[:]), claims.ExpiresAt.Time)
+	if err != nil {
+		return "", "", errors.ErrServerError
+	}
+	if !ok {
+		return "", "", errors.ErrInvalidClient
+	}
+	return clientID, cli.Secret, nil
+}
+
+// jwksVerifyKeys 按 kid 选择验证签名的公钥, 没有 kid 时尝试全部公钥
+func jwksVerifyKeys(set *jwk.Set, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var keys jwt.VerificationKeySet
+	for _, k := range set.Keys {
+		if (kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != token.Method.Alg()) || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys.Keys = append(keys.Keys, pub)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, jwk.ErrUnsupportedKey
+	}
+	return keys, nil
+}
+
+// validAssertionAudience aud 需要包含 issuer, token 端点或者当前请求的端点
+func validAssertionAudience(r *http.Request, aud jwt.ClaimStrings) bool {
+	issuer := config.GetCfg().OAuth2.Issuer
+	base := strings.TrimRight(issuer, "/")
+	for _, v := range aud {
+		if v == issuer || v == base || v == base+"/token" || v == base+r.URL.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// clientSecretHandler 从 basic auth 或表单中获取 client_id/client_secret
+// 按客户端的 token_endpoint_auth_method 限制方式, 配置了 JWT 认证方式的客户端不能再使用 secret
+func clientSecretHandler(r *http.Request) (clientID, clientSecret string, err error) {
+	_, _, basic := r.BasicAuth()
+	if basic {
+		clientID, clientSecret, err = server.ClientBasicHandler(r)
+	} else {
+		clientID, clientSecret, err = server.ClientFormHandler(r)
+	}
+	if err != nil {
+		return
+	}
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil {
+		return
+	}
+	switch method := cli.TokenEndpointAuthMethod; {
+	case isJWTAuthMethod(method),
+		method == AuthMethodSecretBasic && !basic,
+		method == AuthMethodSecretPost && basic:
+		return "", "", errors.ErrInvalidClient
+	}
+	return
+}
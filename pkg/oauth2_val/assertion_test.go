@@ -0,0 +1,207 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/session"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testIssuer = "http://localhost:9096"
+
+func setupAssertion(t *testing.T) *ecdsa.PrivateKey {
+	setupRefresh(t)
+	// Srv 签发 token 时会读取 session
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	session.Setup(ctx)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := jwk.FromPublicKey("k1", "ES256", &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &jwk.Set{Keys: []jwk.JWK{k}}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(set)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.GetCfg()
+	oldIssuer := cfg.OAuth2.Issuer
+	cfg.OAuth2.Issuer = testIssuer
+	t.Cleanup(func() { cfg.OAuth2.Issuer = oldIssuer })
+	cfg.OAuth2.Client = []config.OAuth2Client{
+		{ID: "pk", Secret: "pk_secret", TokenEndpointAuthMethod: oauth2_val.AuthMethodPrivateKeyJWT, JWKS: string(data)},
+		{ID: "pk_file", TokenEndpointAuthMethod: oauth2_val.AuthMethodPrivateKeyJWT, JWKSFile: file},
+		{ID: "hs", Secret: "hs_secret_0123456789abcdef0123456789", TokenEndpointAuthMethod: oauth2_val.AuthMethodClientSecretJWT},
+	}
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signAssertion(t *testing.T, method jwt.SigningMethod, key interface{}, clientID string, edit func(*jwt.RegisteredClaims)) string {
+	claims := &jwt.RegisteredClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{testIssuer + "/token"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		ID:        uuid.NewString(),
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// clientCredentials 使用 client_credentials 换取 token, 返回错误码
+func clientCredentials(t *testing.T, form url.Values, clientID, secret string) string {
+	form.Set("grant_type", "client_credentials")
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		r.SetBasicAuth(clientID, secret)
+	}
+	w := httptest.NewRecorder()
+	if err := oauth2_val.Srv.HandleTokenRequest(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if resp.Error == "" && resp.AccessToken == "" {
+		t.Error("missing access token:", w.Body.String())
+	}
+	return resp.Error
+}
+
+func assertionForm(assertion string) url.Values {
+	return url.Values{"client_assertion_type": {oauth2_val.ClientAssertionType}, "client_assertion": {assertion}}
+}
+
+func TestPrivateKeyJWT(t *testing.T) {
+	key := setupAssertion(t)
+
+	assertion := signAssertion(t, jwt.SigningMethodES256, key, "pk", nil)
+	if errCode := clientCredentials(t, assertionForm(assertion), "", ""); errCode != "" {
+		t.Fatal("private_key_jwt failed:", errCode)
+	}
+	// 同一个 assertion 不能再次使用
+	if errCode := clientCredentials(t, assertionForm(assertion), "", ""); errCode != "invalid_client" {
+		t.Error("replayed assertion should be rejected:", errCode)
+	}
+	// 从文件读取公钥
+	if errCode := clientCredentials(t, assertionForm(signAssertion(t, jwt.SigningMethodES256, key, "pk_file", nil)), "", ""); errCode != "" {
+		t.Error("private_key_jwt with jwks_file failed:", errCode)
+	}
+
+	cases := map[string]func(*jwt.RegisteredClaims){
+		"wrong audience": func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"https://other.example.com/token"} },
+		"missing jti":    func(c *jwt.RegisteredClaims) { c.ID = "" },
+		"expired":        func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) },
+		"too long":       func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(24 * time.Hour)) },
+		"wrong issuer":   func(c *jwt.RegisteredClaims) { c.Issuer = "hs" },
+	}
+	for name, edit := range cases {
+		if errCode := clientCredentials(t, assertionForm(signAssertion(t, jwt.SigningMethodES256, key, "pk", edit)), "", ""); errCode != "invalid_client" {
+			t.Errorf("%s: got %q", name, errCode)
+		}
+	}
+
+	// 使用 secret 签名的 assertion 不能冒充 private_key_jwt
+	hs := signAssertion(t, jwt.SigningMethodHS256, []byte("pk_secret"), "pk", nil)
+	if errCode := clientCredentials(t, assertionForm(hs), "", ""); errCode != "invalid_client" {
+		t.Error("hmac assertion should be rejected:", errCode)
+	}
+	// 配置了 JWT 认证方式的客户端不能直接使用 secret
+	if errCode := clientCredentials(t, url.Values{}, "pk", "pk_secret"); errCode != "invalid_client" {
+		t.Error("client secret should be rejected:", errCode)
+	}
+}
+
+func TestClientSecretJWT(t *testing.T) {
+	setupAssertion(t)
+	secret := []byte("hs_secret_0123456789abcdef0123456789")
+
+	if errCode := clientCredentials(t, assertionForm(signAssertion(t, jwt.SigningMethodHS256, secret, "hs", nil)), "", ""); errCode != "" {
+		t.Fatal("client_secret_jwt failed:", errCode)
+	}
+	if errCode := clientCredentials(t, assertionForm(signAssertion(t, jwt.SigningMethodHS256, []byte("wrong"), "hs", nil)), "", ""); errCode != "invalid_client" {
+		t.Error("wrong secret should be rejected:", errCode)
+	}
+	form := assertionForm(signAssertion(t, jwt.SigningMethodHS256, secret, "hs", nil))
+	form.Set("client_assertion_type", "urn:example:unknown")
+	if errCode := clientCredentials(t, form, "", ""); errCode != "invalid_client" {
+		t.Error("unknown client_assertion_type should be rejected:", errCode)
+	}
+}
+
+func TestClientSecretAuthMethod(t *testing.T) {
+	setupAssertion(t)
+	cfg := config.GetCfg()
+	cfg.OAuth2.Client = []config.OAuth2Client{
+		{ID: "any", Secret: "any_secret"},
+		{ID: "basic", Secret: "basic_secret", TokenEndpointAuthMethod: oauth2_val.AuthMethodSecretBasic},
+		{ID: "post", Secret: "post_secret", TokenEndpointAuthMethod: oauth2_val.AuthMethodSecretPost},
+	}
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+	formAuth := func(clientID, secret string) url.Values {
+		return url.Values{"client_id": {clientID}, "client_secret": {secret}}
+	}
+
+	// 没有配置时两种方式都可以
+	if errCode := clientCredentials(t, url.Values{}, "any", "any_secret"); errCode != "" {
+		t.Error("basic auth failed:", errCode)
+	}
+	if errCode := clientCredentials(t, formAuth("any", "any_secret"), "", ""); errCode != "" {
+		t.Error("form auth failed:", errCode)
+	}
+
+	if errCode := clientCredentials(t, url.Values{}, "basic", "basic_secret"); errCode != "" {
+		t.Error("client_secret_basic with basic auth failed:", errCode)
+	}
+	if errCode := clientCredentials(t, formAuth("basic", "basic_secret"), "", ""); errCode != "invalid_client" {
+		t.Error("client_secret_basic should reject form credentials:", errCode)
+	}
+	if errCode := clientCredentials(t, formAuth("post", "post_secret"), "", ""); errCode != "" {
+		t.Error("client_secret_post with form failed:", errCode)
+	}
+	if errCode := clientCredentials(t, url.Values{}, "post", "post_secret"); errCode != "invalid_client" {
+		t.Error("client_secret_post should reject basic auth:", errCode)
+	}
+}
@@ -0,0 +1,93 @@
+package oauth2_val
+
+import (
+	"context"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// consentExp 用户同意授权的有效期
+func consentExp() time.Duration {
+	days := config.GetCfg().OAuth2.ConsentExp
+	if days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// NeedsConsent 是否需要用户确认授权
+// 自己的应用(first_party)不需要, 之前已同意过全部scope且未过期时也不需要
+func NeedsConsent(ctx context.Context, userID, clientID, scope string) (bool, error) {
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil {
+		return false, errors.ErrInvalidClient
+	}
+	if cli.FirstParty {
+		return false, nil
+	}
+	c, err := model.GetConsent(ctx, userID, clientID)
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	for _, s := range config.ScopeFilter(clientID, scope) {
+		ids = append(ids, s.ID)
+	}
+	return !c.Covers(ids), nil
+}
+
+// GrantConsent 记录用户在确认页面上同意的scope
+// requested 为本次展示给用户的scope, 其中没有勾选的会从之前的授权中移除
+func GrantConsent(ctx context.Context, userID, clientID string, requested, approved []string) error {
+	old, err := model.GetConsent(ctx, userID, clientID)
+	if err != nil {
+		return err
+	}
+	c := &model.Consent{
+		UserID:    userID,
+		ClientID:  clientID,
+		Scope:     []string{},
+		ExpiresAt: time.Now().Add(consentExp()),
+	}
+	if old != nil {
+		for _, s := range old.Scope {
+			if !contains(requested, s) {
+				c.Scope = append(c.Scope, s)
+			}
+		}
+	}
+	for _, s := range approved {
+		if contains(requested, s) && !contains(c.Scope, s) {
+			c.Scope = append(c.Scope, s)
+		}
+	}
+	return model.SaveConsent(ctx, c)
+}
+
+// ConsentedScope 从请求的scope中过滤出用户同意的部分
+func ConsentedScope(ctx context.Context, userID, clientID string, scope []config.Scope) ([]config.Scope, error) {
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil {
+		return nil, errors.ErrInvalidClient
+	}
+	if cli.FirstParty {
+		return scope, nil
+	}
+	c, err := model.GetConsent(ctx, userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.ErrAccessDenied
+	}
+	result := make([]config.Scope, 0, len(scope))
+	for _, s := range scope {
+		if c.Has(s.ID) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
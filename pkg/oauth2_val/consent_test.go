@@ -0,0 +1,54 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"testing"
+)
+
+func TestConsent(t *testing.T) {
+	setupRegistration(t)
+	old := config.GetCfg().OAuth2.Client
+	config.GetCfg().OAuth2.Client = []config.OAuth2Client{
+		{ID: "third", Scope: []config.Scope{{ID: "openid"}, {ID: "email"}, {ID: "phone"}}},
+		{ID: "first", FirstParty: true, Scope: []config.Scope{{ID: "openid"}}},
+	}
+	t.Cleanup(func() { config.GetCfg().OAuth2.Client = old })
+	ctx := context.Background()
+
+	need, err := oauth2_val.NeedsConsent(ctx, "1", "first", "openid")
+	if err != nil || need {
+		t.Errorf("first party client should not need consent: %v %v", need, err)
+	}
+	need, err = oauth2_val.NeedsConsent(ctx, "1", "third", "openid email")
+	if err != nil || !need {
+		t.Fatalf("new client should need consent: %v %v", need, err)
+	}
+
+	// 只同意了 openid
+	if err := oauth2_val.GrantConsent(ctx, "1", "third", []string{"openid", "email"}, []string{"openid"}); err != nil {
+		t.Fatal(err)
+	}
+	if need, _ := oauth2_val.NeedsConsent(ctx, "1", "third", "openid"); need {
+		t.Error("consented scope should not need consent again")
+	}
+	if need, _ := oauth2_val.NeedsConsent(ctx, "1", "third", "openid email"); !need {
+		t.Error("declined scope should need consent again")
+	}
+	if need, _ := oauth2_val.NeedsConsent(ctx, "2", "third", "openid"); !need {
+		t.Error("consent should be per user")
+	}
+	scope, err := oauth2_val.ConsentedScope(ctx, "1", "third", config.ScopeFilter("third", "openid email"))
+	if err != nil || config.JoinScope(scope) != "openid" {
+		t.Errorf("consented scope: %v %v", scope, err)
+	}
+
+	// 之后同意了 phone, 之前的 openid 保留
+	if err := oauth2_val.GrantConsent(ctx, "1", "third", []string{"phone"}, []string{"phone"}); err != nil {
+		t.Fatal(err)
+	}
+	if need, _ := oauth2_val.NeedsConsent(ctx, "1", "third", "openid phone"); need {
+		t.Error("consent should be merged with earlier decisions")
+	}
+}
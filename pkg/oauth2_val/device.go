@@ -0,0 +1,205 @@
+package oauth2_val
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/storage"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// DeviceCodeGrantType 设备授权的 grant_type (RFC 8628)
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// devicePollInterval 设备轮询 token 端点的最小间隔, 单位秒
+// 轮询过快时返回 slow_down, 并把间隔增加5秒
+const devicePollInterval = 5
+
+// userCodeChars user_code 使用的字符, 去掉了元音和容易混淆的字符 (RFC 8628 6.1)
+const userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+// deviceStore 与当前 token 存储对应的设备授权请求存储
+var deviceStore storage.DeviceStore
+
+// ErrDeviceNotPending 设备授权请求已经被处理过, 不能再修改
+var ErrDeviceNotPending = errors.New("device authorization is no longer pending")
+
+func deviceCodeExp() time.Duration {
+	seconds := config.GetCfg().OAuth2.DeviceCodeExp
+	if seconds <= 0 {
+		seconds = 600
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// NewDeviceAuthorization 为客户端创建设备授权请求
+func NewDeviceAuthorization(ctx context.Context, clientID, scope string) (*storage.DeviceAuthorization, error) {
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil {
+		return nil, errors.ErrInvalidClient
+	}
+	if !cli.AllowsGrantType(DeviceCodeGrantType) {
+		return nil, errors.ErrUnauthorizedClient
+	}
+	d := &storage.DeviceAuthorization{
+		DeviceCode: RandomToken(),
+		ClientID:   clientID,
+		Scope:      config.JoinScope(config.ScopeFilter(clientID, scope)),
+		Status:     storage.DeviceStatusPending,
+		Interval:   devicePollInterval,
+		ExpiresAt:  time.Now().Add(deviceCodeExp()),
+	}
+	// user_code 比较短, 重复时重新生成
+	for i := 0; ; i++ {
+		d.UserCode = newUserCode()
+		err := deviceStore.CreateDevice(ctx, d)
+		if err == nil {
+			return d, nil
+		}
+		if err != storage.ErrDuplicateUserCode || i >= 3 {
+			return nil, err
+		}
+	}
+}
+
+// newUserCode 生成8位的 user_code, 显示为 XXXX-XXXX
+func newUserCode() string {
+	b := make([]byte, 8)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeChars))))
+		if err != nil {
+			panic(err)
+		}
+		b[i] = userCodeChars[n.Int64()]
+	}
+	return string(b)
+}
+
+// FormatUserCode 显示给用户的 user_code
+func FormatUserCode(userCode string) string {
+	if len(userCode) != 8 {
+		return userCode
+	}
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+// normalizeUserCode 用户输入的 user_code 不区分大小写, 忽略横线和空格
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	return strings.NewReplacer("-", "", " ", "").Replace(userCode)
+}
+
+// PendingDevice 根据用户输入的 user_code 查找等待确认的设备授权请求
+// 不存在, 已过期或者已经处理过时返回nil
+func PendingDevice(ctx context.Context, userCode string) (*storage.DeviceAuthorization, error) {
+	userCode = normalizeUserCode(userCode)
+	if userCode == "" {
+		return nil, nil
+	}
+	d, err := deviceStore.GetDeviceByUserCode(ctx, userCode)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	if d.Status != storage.DeviceStatusPending || time.Now().After(d.ExpiresAt) {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// CompleteDevice 记录用户的选择, 拒绝或没有同意任何scope时视为拒绝
+// 设备下次轮询时会拿到 token 或 access_denied, 请求已经被处理过时返回 ErrDeviceNotPending
+func CompleteDevice(ctx context.Context, d *storage.DeviceAuthorization, userID string, allow bool, approved []string) error {
+	var scope []string
+	for _, s := range config.SplitScope(d.Scope) {
+		if contains(approved, s) {
+			scope = append(scope, s)
+		}
+	}
+	if !allow || (len(scope) == 0 && d.Scope != "") {
+		d.Status = storage.DeviceStatusDenied
+	} else {
+		d.Status = storage.DeviceStatusApproved
+		d.UserID = userID
+		d.Scope = strings.Join(scope, ",")
+	}
+	ok, err := deviceStore.UpdateDevice(ctx, d, storage.DeviceStatusPending)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDeviceNotPending
+	}
+	return nil
+}
+
+// HandleDeviceTokenRequest token 端点处理 device_code 授权方式
+// 用户确认之前返回 authorization_pending, 轮询过快返回 slow_down, 过期返回 expired_token
+func HandleDeviceTokenRequest(w http.ResponseWriter, r *http.Request) error {
+	cli, err := AuthenticateClient(r)
+	if err != nil {
+		return WriteTokenError(w, errors.ErrInvalidClient)
+	}
+	if c := config.GetOAuth2Client(cli.GetID()); c == nil || !c.AllowsGrantType(DeviceCodeGrantType) {
+		return WriteTokenError(w, errors.ErrUnauthorizedClient)
+	}
+	deviceCode := r.PostFormValue("device_code")
+	if deviceCode == "" {
+		return WriteTokenError(w, errors.ErrInvalidRequest)
+	}
+
+	ctx := r.Context()
+	d, err := deviceStore.GetDeviceByDeviceCode(ctx, deviceCode)
+	if err != nil {
+		return WriteTokenError(w, err)
+	}
+	if d == nil || d.ClientID != cli.GetID() {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+
+	now := time.Now()
+	switch {
+	case now.After(d.ExpiresAt):
+		return WriteTokenError(w, ErrExpiredToken)
+	case d.Status == storage.DeviceStatusDenied:
+		deviceStore.RemoveDevice(ctx, deviceCode)
+		return WriteTokenError(w, errors.ErrAccessDenied)
+	case d.Status == storage.DeviceStatusPending:
+		pollErr := ErrAuthorizationPending
+		if now.Sub(d.LastPolledAt) < time.Duration(d.Interval)*time.Second {
+			d.Interval += 5
+			pollErr = ErrSlowDown
+		}
+		d.LastPolledAt = now
+		// 读取之后用户已经确认时不覆盖, 下次轮询拿到结果
+		if _, err := deviceStore.UpdateDevice(ctx, d, storage.DeviceStatusPending); err != nil {
+			return WriteTokenError(w, err)
+		}
+		return WriteTokenError(w, pollErr)
+	}
+
+	// 已确认, 只能兑换一次
+	if ok, err := deviceStore.RemoveDevice(ctx, deviceCode); err != nil {
+		return WriteTokenError(w, err)
+	} else if !ok {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+	// 设备授权没有单独的配置, 与密码模式一样直接签发给用户, refresh token 使用密码模式的配置;
+	// access token 与授权码模式一样使用 access_token_exp, 为0时使用密码模式的默认值
+	ti, err := Mgr.GenerateAccessToken(ctx, oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:       cli.GetID(),
+		ClientSecret:   cli.GetSecret(),
+		UserID:         d.UserID,
+		Scope:          d.Scope,
+		AccessTokenExp: time.Hour * time.Duration(config.GetCfg().OAuth2.AccessTokenExp),
+	})
+	if err != nil {
+		return WriteTokenError(w, err)
+	}
+	return WriteToken(w, Srv.GetTokenData(ti), nil, http.StatusOK)
+}
@@ -0,0 +1,106 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+	"testing"
+)
+
+// pollDevice 使用 device_code 轮询 token 端点, 返回 access token 和错误码
+func pollDevice(t *testing.T, deviceCode string) (string, string) {
+	form := url.Values{"grant_type": {oauth2_val.DeviceCodeGrantType}, "device_code": {deviceCode}}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("app", "secret")
+	w := httptest.NewRecorder()
+	if err := oauth2_val.HandleDeviceTokenRequest(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	return resp.AccessToken, resp.Error
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	setupRefresh(t)
+	ctx := context.Background()
+
+	d, err := oauth2_val.NewDeviceAuthorization(ctx, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errCode := pollDevice(t, d.DeviceCode); errCode != "authorization_pending" {
+		t.Error("expected authorization_pending:", errCode)
+	}
+	// 没有等待 interval 就再次轮询
+	if _, errCode := pollDevice(t, d.DeviceCode); errCode != "slow_down" {
+		t.Error("expected slow_down:", errCode)
+	}
+
+	// 用户输入的验证码不区分大小写
+	pending, err := oauth2_val.PendingDevice(ctx, strings.ToLower(oauth2_val.FormatUserCode(d.UserCode)))
+	if err != nil || pending == nil {
+		t.Fatal("pending device not found:", err)
+	}
+	if err := oauth2_val.CompleteDevice(ctx, pending, "1", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if token, errCode := pollDevice(t, d.DeviceCode); errCode != "" || token == "" {
+		t.Fatal("device token request failed:", errCode)
+	}
+	// device_code 只能使用一次
+	if _, errCode := pollDevice(t, d.DeviceCode); errCode != "invalid_grant" {
+		t.Error("device_code should be used only once:", errCode)
+	}
+	if pending, _ := oauth2_val.PendingDevice(ctx, d.UserCode); pending != nil {
+		t.Error("user_code should not be pending")
+	}
+
+	// 用户拒绝
+	d, err = oauth2_val.NewDeviceAuthorization(ctx, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := oauth2_val.CompleteDevice(ctx, d, "1", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, errCode := pollDevice(t, d.DeviceCode); errCode != "access_denied" {
+		t.Error("expected access_denied:", errCode)
+	}
+}
+
+func TestDeviceConcurrentComplete(t *testing.T) {
+	setupRefresh(t)
+	ctx := context.Background()
+
+	d, err := oauth2_val.NewDeviceAuthorization(ctx, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两个页面同时打开, 先提交的生效
+	first, _ := oauth2_val.PendingDevice(ctx, d.UserCode)
+	second, _ := oauth2_val.PendingDevice(ctx, d.UserCode)
+	if first == nil || second == nil {
+		t.Fatal("pending device not found")
+	}
+	if err := oauth2_val.CompleteDevice(ctx, first, "1", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := oauth2_val.CompleteDevice(ctx, second, "1", false, nil); err != oauth2_val.ErrDeviceNotPending {
+		t.Error("completed device should not be changed:", err)
+	}
+	// 确认之后的轮询拿到 token, 不会把状态改回 pending
+	if token, errCode := pollDevice(t, d.DeviceCode); errCode != "" || token == "" {
+		t.Fatal("device token request failed:", errCode)
+	}
+}
@@ -0,0 +1,45 @@
+package oauth2_val
+
+import (
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// 扩展的错误类型
+// 注册到errors.Descriptions和errors.StatusCodes之后, Srv会按标准格式返回
+var (
+	// ErrInsufficientScope token的权限范围不足 (RFC 6750)
+	ErrInsufficientScope = errors.New("insufficient_scope")
+	// ErrCodeVerifierRequired 客户端要求PKCE, 但换取token时没有提供 code_verifier
+	ErrCodeVerifierRequired = errors.New("invalid_request")
+	// ErrInvalidPrompt prompt=none 和其他值一起使用
+	ErrInvalidPrompt = errors.New("invalid_request")
+	// ErrLoginRequired prompt=none 但用户需要登录 (OpenID Connect Core 3.1.2.6)
+	ErrLoginRequired = errors.New("login_required")
+	// ErrConsentRequired prompt=none 但用户需要确认授权
+	ErrConsentRequired = errors.New("consent_required")
+	// ErrAuthorizationPending 设备授权还没有被用户确认 (RFC 8628 3.5)
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown 设备轮询过快, 需要增加5秒的间隔
+	ErrSlowDown = errors.New("slow_down")
+	// ErrExpiredToken device_code 已过期, 需要重新发起设备授权
+	ErrExpiredToken = errors.New("expired_token")
+	// ErrInvalidTarget token exchange 申请了不允许的受众 (RFC 8693 2.2.2)
+	ErrInvalidTarget = errors.New("invalid_target")
+)
+
+func init() {
+	register(ErrInsufficientScope, "The request requires higher privileges than provided by the access token", 403)
+	register(ErrCodeVerifierRequired, "PKCE is required. code_verifier is missing", 400)
+	register(ErrInvalidPrompt, "prompt=none must not be combined with other values", 400)
+	register(ErrLoginRequired, "The authorization server requires end-user authentication", 400)
+	register(ErrConsentRequired, "The authorization server requires end-user consent", 400)
+	register(ErrAuthorizationPending, "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps", 400)
+	register(ErrSlowDown, "The authorization request is still pending and polling should continue, but the interval must be increased by 5 seconds", 400)
+	register(ErrExpiredToken, "The device_code has expired, and the device authorization session has concluded", 400)
+	register(ErrInvalidTarget, "The requested audience is invalid, unknown, or not allowed for this client", 400)
+}
+
+func register(err error, description string, statusCode int) {
+	errors.Descriptions[err] = description
+	errors.StatusCodes[err] = statusCode
+}
@@ -0,0 +1,180 @@
+package oauth2_val
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// TokenExchangeGrantType token exchange 的 grant_type (RFC 8693)
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// TokenTypeAccessToken 目前只支持交换 access token, 签发的也是 access token
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// token 扩展字段中记录的受众和委托链
+const (
+	extAudience = "aud"
+	// Actor 的JSON
+	extActor = "act"
+)
+
+// Actor 代表用户调用下游服务的一方, 多次委托时嵌套记录之前的 Actor (RFC 8693 4.1)
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
+// tokenActor 读取 token 扩展字段中的委托链, 没有时返回nil
+func tokenActor(ti oauth2.TokenInfo) *Actor {
+	ext := tokenExtension(ti)
+	if ext.Get(extActor) == "" {
+		return nil
+	}
+	act := &Actor{}
+	if err := json.Unmarshal([]byte(ext.Get(extActor)), act); err != nil {
+		return nil
+	}
+	return act
+}
+
+// tokenAudience token 的受众, 没有通过 token exchange 指定时为签发的客户端
+func tokenAudience(ti oauth2.TokenInfo) []string {
+	if aud := tokenExtension(ti)[extAudience]; len(aud) > 0 {
+		return aud
+	}
+	return []string{ti.GetClientID()}
+}
+
+func tokenExtension(ti oauth2.TokenInfo) url.Values {
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		return eti.GetExtension()
+	}
+	return url.Values{}
+}
+
+type requestExtensionKey struct{}
+
+// withRequestExtension 在请求中附加要写入新 token 的扩展字段, 由 extractExtension 读取
+func withRequestExtension(r *http.Request, ext url.Values) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestExtensionKey{}, ext))
+}
+
+func requestExtension(r *http.Request) url.Values {
+	ext, _ := r.Context().Value(requestExtensionKey{}).(url.Values)
+	return ext
+}
+
+// HandleTokenExchangeRequest token 端点处理 token exchange 授权方式
+// 没有 actor_token 时为模拟(impersonation), 新 token 直接代表用户;
+// 有 actor_token 时为委托(delegation), 新 token 的 act 声明记录调用方
+func HandleTokenExchangeRequest(w http.ResponseWriter, r *http.Request) error {
+	cli, err := AuthenticateClient(r)
+	if err != nil || cli.IsPublic() {
+		return WriteTokenError(w, errors.ErrInvalidClient)
+	}
+	// 没有配置可以申请的受众时不能使用 token exchange
+	c := config.GetOAuth2Client(cli.GetID())
+	if c == nil || !c.AllowsGrantType(TokenExchangeGrantType) || len(c.TokenExchangeAudiences) == 0 {
+		return WriteTokenError(w, errors.ErrUnauthorizedClient)
+	}
+	if r.PostFormValue("subject_token") == "" || r.PostFormValue("subject_token_type") != TokenTypeAccessToken {
+		return WriteTokenError(w, errors.ErrInvalidRequest)
+	}
+	if t := r.PostFormValue("requested_token_type"); t != "" && t != TokenTypeAccessToken {
+		return WriteTokenError(w, errors.ErrInvalidRequest)
+	}
+
+	ctx := r.Context()
+	subject, tokenType := LoadToken(ctx, r.PostFormValue("subject_token"), "access_token")
+	if subject == nil || tokenType != "access_token" {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+
+	// 只能申请配置中允许的受众, 没有指定时为客户端自己
+	audience := r.PostForm["audience"]
+	for _, aud := range audience {
+		if aud != cli.GetID() && !contains(c.TokenExchangeAudiences, aud) {
+			return WriteTokenError(w, ErrInvalidTarget)
+		}
+	}
+	if len(audience) == 0 {
+		audience = []string{cli.GetID()}
+	}
+
+	// 新 token 的 scope 不能超过原 token 和客户端注册的范围, 没有指定时取两者的交集
+	var scope []string
+	for _, s := range config.ScopeFilter(cli.GetID(), subject.GetScope()) {
+		scope = append(scope, s.ID)
+	}
+	if requested := config.SplitScope(r.PostFormValue("scope")); len(requested) > 0 {
+		for _, s := range requested {
+			if !contains(scope, s) {
+				return WriteTokenError(w, errors.ErrInvalidScope)
+			}
+		}
+		scope = requested
+	}
+
+	ext := url.Values{extAudience: audience}
+	// 保留用户的登录时间
+	if v := tokenExtension(subject).Get("auth_time"); v != "" {
+		ext.Set("auth_time", v)
+	}
+	act := tokenActor(subject)
+	if actorToken := r.PostFormValue("actor_token"); actorToken != "" {
+		if r.PostFormValue("actor_token_type") != TokenTypeAccessToken {
+			return WriteTokenError(w, errors.ErrInvalidRequest)
+		}
+		// 调用方只能以自己的身份出现在委托链中
+		actor, tokenType := LoadToken(ctx, actorToken, "access_token")
+		if actor == nil || tokenType != "access_token" || actor.GetClientID() != cli.GetID() {
+			return WriteTokenError(w, errors.ErrInvalidGrant)
+		}
+		act = &Actor{Subject: actor.GetUserID(), ClientID: actor.GetClientID(), Actor: act}
+		if act.Subject == "" {
+			act.Subject = actor.GetClientID()
+		}
+	} else if r.PostFormValue("actor_token_type") != "" {
+		return WriteTokenError(w, errors.ErrInvalidRequest)
+	}
+	if act != nil {
+		b, err := json.Marshal(act)
+		if err != nil {
+			return WriteTokenError(w, err)
+		}
+		ext.Set(extActor, string(b))
+	}
+
+	// 新 token 不能比原 token 活得更久, 也不签发 refresh token
+	exp := time.Hour * time.Duration(config.GetCfg().OAuth2.AccessTokenExp)
+	if left := time.Until(subject.GetAccessCreateAt().Add(subject.GetAccessExpiresIn())); subject.GetAccessExpiresIn() > 0 && left < exp {
+		exp = left
+	}
+	ti, err := Mgr.GenerateAccessToken(ctx, oauth2.ClientCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:       cli.GetID(),
+		ClientSecret:   cli.GetSecret(),
+		UserID:         subject.GetUserID(),
+		Scope:          strings.Join(scope, ","),
+		AccessTokenExp: exp,
+		Request:        withRequestExtension(r, ext),
+	})
+	if err != nil {
+		return WriteTokenError(w, err)
+	}
+	return WriteToken(w, map[string]interface{}{
+		"access_token":      ti.GetAccess(),
+		"issued_token_type": TokenTypeAccessToken,
+		"token_type":        Srv.Config.TokenType,
+		"expires_in":        int64(ti.GetAccessExpiresIn() / time.Second),
+		"scope":             ti.GetScope(),
+	}, nil, http.StatusOK)
+}
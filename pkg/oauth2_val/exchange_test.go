@@ -0,0 +1,133 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+func setupExchange(t *testing.T) {
+	setupRefresh(t)
+	cfg := config.GetCfg()
+	scope := []config.Scope{{ID: "profile", Title: "profile"}, {ID: "email", Title: "email"}}
+	cfg.OAuth2.Client = []config.OAuth2Client{
+		{ID: "app", Secret: "secret", Scope: scope},
+		{ID: "svc", Secret: "svc_secret", Scope: scope, TokenExchangeAudiences: []string{"downstream"}},
+		{ID: "other", Secret: "other_secret", Scope: scope},
+	}
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func issueToken(t *testing.T, clientID, secret, userID, scope string) string {
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(context.Background(), oauth2.ClientCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     clientID,
+		ClientSecret: secret,
+		UserID:       userID,
+		Scope:        scope,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ti.GetAccess()
+}
+
+// exchange 使用 token exchange 换取 token, 返回新的 access token 和错误码
+func exchange(t *testing.T, clientID, secret string, form url.Values) (string, string) {
+	form.Set("grant_type", oauth2_val.TokenExchangeGrantType)
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientID, secret)
+	w := httptest.NewRecorder()
+	if err := oauth2_val.HandleTokenExchangeRequest(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		Error           string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if resp.Error == "" && resp.IssuedTokenType != oauth2_val.TokenTypeAccessToken {
+		t.Error("unexpected issued_token_type:", resp.IssuedTokenType)
+	}
+	return resp.AccessToken, resp.Error
+}
+
+func TestTokenExchange(t *testing.T) {
+	setupExchange(t)
+	ctx := context.Background()
+	subject := issueToken(t, "app", "secret", "1", "profile,email")
+
+	// 模拟: 受众为下游服务, scope 缩小
+	token, errCode := exchange(t, "svc", "svc_secret", url.Values{
+		"subject_token":      {subject},
+		"subject_token_type": {oauth2_val.TokenTypeAccessToken},
+		"audience":           {"downstream"},
+		"scope":              {"profile"},
+	})
+	if errCode != "" {
+		t.Fatal("token exchange failed:", errCode)
+	}
+	claims, err := oauth2_val.ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1" || claims.Scope != "profile" || len(claims.Audience) != 1 || claims.Audience[0] != "downstream" || claims.Act != nil {
+		t.Error("unexpected claims:", claims)
+	}
+
+	// 委托: act 记录调用方
+	actor := issueToken(t, "svc", "svc_secret", "", "")
+	token, errCode = exchange(t, "svc", "svc_secret", url.Values{
+		"subject_token":      {subject},
+		"subject_token_type": {oauth2_val.TokenTypeAccessToken},
+		"actor_token":        {actor},
+		"actor_token_type":   {oauth2_val.TokenTypeAccessToken},
+	})
+	if errCode != "" {
+		t.Fatal("token exchange failed:", errCode)
+	}
+	claims, err = oauth2_val.ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Act == nil || claims.Act.Subject != "svc" || claims.Audience[0] != "svc" || claims.Scope != "profile,email" {
+		t.Error("unexpected claims:", claims)
+	}
+	if data := oauth2_val.Introspect(ctx, token, ""); data["act"] == nil || data["sub"] != "1" {
+		t.Error("unexpected introspection:", data)
+	}
+
+	cases := []struct {
+		clientID, secret string
+		form             url.Values
+		want             string
+	}{
+		// 没有配置受众的客户端
+		{"other", "other_secret", url.Values{"subject_token": {subject}, "subject_token_type": {oauth2_val.TokenTypeAccessToken}}, "unauthorized_client"},
+		{"svc", "svc_secret", url.Values{"subject_token": {subject}, "subject_token_type": {oauth2_val.TokenTypeAccessToken}, "audience": {"other"}}, "invalid_target"},
+		{"svc", "svc_secret", url.Values{"subject_token": {subject}, "subject_token_type": {oauth2_val.TokenTypeAccessToken}, "scope": {"admin"}}, "invalid_scope"},
+		{"svc", "svc_secret", url.Values{"subject_token": {"invalid"}, "subject_token_type": {oauth2_val.TokenTypeAccessToken}}, "invalid_grant"},
+		{"svc", "svc_secret", url.Values{"subject_token": {subject}}, "invalid_request"},
+		// actor token 必须属于调用方
+		{"svc", "svc_secret", url.Values{"subject_token": {subject}, "subject_token_type": {oauth2_val.TokenTypeAccessToken}, "actor_token": {subject}, "actor_token_type": {oauth2_val.TokenTypeAccessToken}}, "invalid_grant"},
+	}
+	for _, c := range cases {
+		if _, errCode := exchange(t, c.clientID, c.secret, c.form); errCode != c.want {
+			t.Errorf("%s %v: got %q, want %q", c.clientID, c.form, errCode, c.want)
+		}
+	}
+}
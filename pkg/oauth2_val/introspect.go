@@ -0,0 +1,121 @@
+package oauth2_val
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// AuthenticateClient 验证请求中携带的客户端身份
+// 支持 basic auth 和表单, 公开客户端只校验 client_id
+func AuthenticateClient(r *http.Request) (oauth2.ClientInfo, error) {
+	clientID, clientSecret, err := Srv.ClientInfoHandler(r)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+	cli, err := Mgr.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+	if v, ok := cli.(oauth2.ClientPasswordVerifier); ok {
+		if !v.VerifyPassword(clientSecret) {
+			return nil, errors.ErrInvalidClient
+		}
+	} else if secret := cli.GetSecret(); secret != "" &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(clientSecret)) != 1 {
+		return nil, errors.ErrInvalidClient
+	}
+	return cli, nil
+}
+
+// LoadToken 按 token_type_hint 的顺序查找 access token 或 refresh token
+// 返回的 tokenType 为 access_token 或 refresh_token, 找不到时返回 nil
+func LoadToken(ctx context.Context, token, hint string) (ti oauth2.TokenInfo, tokenType string) {
+	loadAccess := func() oauth2.TokenInfo {
+		// 先验证签名, 避免无效的JWT打到存储上
+		if _, err := ParseAccessToken(token); err != nil {
+			return nil
+		}
+		ti, err := Mgr.LoadAccessToken(ctx, token)
+		if err != nil {
+			return nil
+		}
+		return ti
+	}
+	loadRefresh := func() oauth2.TokenInfo {
+		ti, err := Mgr.LoadRefreshToken(ctx, token)
+		if err != nil {
+			return nil
+		}
+		return ti
+	}
+
+	if hint == "refresh_token" {
+		if ti = loadRefresh(); ti != nil {
+			return ti, "refresh_token"
+		}
+		if ti = loadAccess(); ti != nil {
+			return ti, "access_token"
+		}
+		return nil, ""
+	}
+	if ti = loadAccess(); ti != nil {
+		return ti, "access_token"
+	}
+	if ti = loadRefresh(); ti != nil {
+		return ti, "refresh_token"
+	}
+	return nil, ""
+}
+
+// Introspect 按 RFC 7662 返回 token 的状态
+// 无效、过期或已撤销的 token 只返回 {"active": false}
+func Introspect(ctx context.Context, token, hint string) map[string]interface{} {
+	ti, tokenType := LoadToken(ctx, token, hint)
+	if ti == nil {
+		return map[string]interface{}{"active": false}
+	}
+
+	data := map[string]interface{}{
+		"active":    true,
+		"scope":     strings.Join(config.SplitScope(ti.GetScope()), " "),
+		"client_id": ti.GetClientID(),
+		"iss":       config.GetCfg().OAuth2.Issuer,
+	}
+	// 一般为签发的客户端, token exchange 指定了多个受众时返回数组
+	if aud := tokenAudience(ti); len(aud) > 1 {
+		data["aud"] = aud
+	} else {
+		data["aud"] = aud[0]
+	}
+	if act := tokenActor(ti); act != nil {
+		data["act"] = act
+	}
+	var createAt time.Time
+	var expiresIn time.Duration
+	if tokenType == "access_token" {
+		createAt, expiresIn = ti.GetAccessCreateAt(), ti.GetAccessExpiresIn()
+		data["token_type"] = Srv.Config.TokenType
+	} else {
+		createAt, expiresIn = ti.GetRefreshCreateAt(), ti.GetRefreshExpiresIn()
+		data["token_type"] = "refresh_token"
+	}
+	data["iat"] = createAt.Unix()
+	if expiresIn > 0 {
+		data["exp"] = createAt.Add(expiresIn).Unix()
+	}
+	if userID := ti.GetUserID(); userID != "" {
+		data["sub"] = userID
+		if user, err := model.GetUserByID(ctx, userID); err == nil {
+			data["username"] = user.Username
+		}
+	}
+	return data
+}
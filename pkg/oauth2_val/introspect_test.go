@@ -0,0 +1,147 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+func setupIntrospect(t *testing.T) {
+	setupExchange(t)
+	cfg := config.GetCfg()
+	cfg.OAuth2.Issuer = testIssuer
+	cfg.OAuth2.Client = append(cfg.OAuth2.Client, config.OAuth2Client{ID: "spa", Public: true})
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.GlobalDB.AutoMigrate(model.User{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.SaveUser(context.Background(), &model.User{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	setupIntrospect(t)
+	cases := []struct {
+		name   string
+		basic  []string
+		form   url.Values
+		client string
+	}{
+		{"basic auth", []string{"app", "secret"}, nil, "app"},
+		{"form", nil, url.Values{"client_id": {"app"}, "client_secret": {"secret"}}, "app"},
+		{"public client without secret", nil, url.Values{"client_id": {"spa"}}, "spa"},
+		{"wrong secret", []string{"app", "wrong"}, nil, ""},
+		{"missing secret", nil, url.Values{"client_id": {"app"}}, ""},
+		{"unknown client", []string{"nobody", "secret"}, nil, ""},
+		{"no credentials", nil, nil, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(c.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if c.basic != nil {
+			r.SetBasicAuth(c.basic[0], c.basic[1])
+		}
+		cli, err := oauth2_val.AuthenticateClient(r)
+		if c.client == "" {
+			if err == nil {
+				t.Errorf("%s: should be rejected", c.name)
+			}
+		} else if err != nil || cli.GetID() != c.client {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	setupIntrospect(t)
+	ctx := context.Background()
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(ctx, oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     "app",
+		ClientSecret: "secret",
+		UserID:       "1",
+		Scope:        "profile",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hint 只决定查找顺序, 类型不对时继续查找另一种
+	for _, hint := range []string{"", "access_token", "refresh_token", "unknown"} {
+		if found, tokenType := oauth2_val.LoadToken(ctx, ti.GetAccess(), hint); found == nil || tokenType != "access_token" {
+			t.Errorf("access token with hint %q: got %q", hint, tokenType)
+		}
+		if found, tokenType := oauth2_val.LoadToken(ctx, ti.GetRefresh(), hint); found == nil || tokenType != "refresh_token" {
+			t.Errorf("refresh token with hint %q: got %q", hint, tokenType)
+		}
+	}
+	if found, _ := oauth2_val.LoadToken(ctx, "not-a-token", ""); found != nil {
+		t.Error("unknown token should not be found")
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	setupIntrospect(t)
+	ctx := context.Background()
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(ctx, oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     "app",
+		ClientSecret: "secret",
+		UserID:       "1",
+		Scope:        "profile,email",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := oauth2_val.Introspect(ctx, ti.GetAccess(), "")
+	if data["active"] != true || data["token_type"] != "Bearer" || data["scope"] != "profile email" ||
+		data["client_id"] != "app" || data["aud"] != "app" || data["iss"] != testIssuer ||
+		data["sub"] != "1" || data["username"] != "alice" {
+		t.Errorf("unexpected access token introspection: %v", data)
+	}
+	if exp, iat := data["exp"].(int64), data["iat"].(int64); exp != iat+int64(ti.GetAccessExpiresIn()/time.Second) {
+		t.Errorf("unexpected exp %d iat %d", exp, iat)
+	}
+	if data := oauth2_val.Introspect(ctx, ti.GetRefresh(), "refresh_token"); data["active"] != true || data["token_type"] != "refresh_token" {
+		t.Errorf("unexpected refresh token introspection: %v", data)
+	}
+
+	// 撤销之后不再有效, 也不返回其他字段
+	app, _ := oauth2_val.Mgr.GetClient(ctx, "app")
+	if err := oauth2_val.RevokeToken(ctx, app, ti.GetRefresh(), "refresh_token"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{ti.GetAccess(), ti.GetRefresh(), "not-a-token"} {
+		if data := oauth2_val.Introspect(ctx, token, ""); data["active"] != false || len(data) != 1 {
+			t.Errorf("revoked or unknown token should be inactive: %v", data)
+		}
+	}
+
+	// 过期
+	ti, err = oauth2_val.Mgr.GenerateAccessToken(ctx, oauth2.ClientCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:       "app",
+		ClientSecret:   "secret",
+		Scope:          "profile",
+		AccessTokenExp: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := oauth2_val.Introspect(ctx, ti.GetAccess(), ""); data["active"] != true {
+		t.Fatalf("token should be active before expiry: %v", data)
+	}
+	time.Sleep(2 * time.Second)
+	if data := oauth2_val.Introspect(ctx, ti.GetAccess(), ""); data["active"] != false {
+		t.Errorf("expired token should be inactive: %v", data)
+	}
+}
@@ -0,0 +1,140 @@
+package oauth2_val
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTBearerGrantType jwt-bearer 的 grant_type (RFC 7523 2.1)
+const JWTBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// assertion 的 sub 对应的用户字段
+const (
+	SubjectMappingUsername = "username"
+	SubjectMappingEmail    = "email"
+)
+
+// trustedIssuer 按 iss 查找配置中信任的签发方
+func trustedIssuer(iss string) *config.TrustedIssuer {
+	issuers := config.GetCfg().OAuth2.JWTBearer.Issuers
+	for i := range issuers {
+		if iss != "" && issuers[i].Issuer == iss {
+			return &issuers[i]
+		}
+	}
+	return nil
+}
+
+// assertionUser 按签发方的配置把 sub 对应到用户, 找不到或被禁用时返回 invalid_grant
+func assertionUser(ctx context.Context, iss *config.TrustedIssuer, subject string) (*model.User, error) {
+	var u *model.User
+	var err error
+	switch iss.SubjectMapping {
+	case "", SubjectMappingUsername:
+		u, err = model.GetUserByUsername(ctx, subject)
+	case SubjectMappingEmail:
+		u, err = model.GetUserByEmail(ctx, subject)
+	default:
+		log.Println("Unsupported subject_mapping:", iss.SubjectMapping)
+		return nil, errors.ErrServerError
+	}
+	if err != nil || u.Disabled {
+		return nil, errors.ErrInvalidGrant
+	}
+	return u, nil
+}
+
+// HandleJWTBearerRequest token 端点处理 jwt-bearer 授权方式
+// assertion 由配置中信任的签发方签名, 验证通过后直接为 sub 对应的用户签发 access token
+func HandleJWTBearerRequest(w http.ResponseWriter, r *http.Request) error {
+	cli, err := AuthenticateClient(r)
+	if err != nil || cli.IsPublic() {
+		return WriteTokenError(w, errors.ErrInvalidClient)
+	}
+	c := config.GetOAuth2Client(cli.GetID())
+	if c == nil || !c.AllowsGrantType(JWTBearerGrantType) {
+		return WriteTokenError(w, errors.ErrUnauthorizedClient)
+	}
+	assertion := r.PostFormValue("assertion")
+	if assertion == "" {
+		return WriteTokenError(w, errors.ErrInvalidRequest)
+	}
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, claims); err != nil {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+	// 签发方需要明确允许当前客户端使用
+	iss := trustedIssuer(claims.Issuer)
+	if iss == nil || !contains(iss.Clients, cli.GetID()) {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+	set, err := loadJWKS(iss.JWKS, iss.JWKSFile)
+	if err != nil {
+		log.Println("Load jwks of trusted issuer error:", err)
+		return WriteTokenError(w, errors.ErrServerError)
+	}
+
+	claims = &jwt.RegisteredClaims{}
+	_, err = jwt.NewParser(
+		jwt.WithValidMethods(privateKeyJWTAlgs),
+		jwt.WithIssuer(iss.Issuer),
+		jwt.WithExpirationRequired(),
+	).ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwksVerifyKeys(set, token)
+	})
+	if err != nil || claims.Subject == "" || !validAssertionAudience(r, claims.Audience) {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+	if claims.ID == "" || time.Until(claims.ExpiresAt.Time) > assertionMaxAge {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+	// 同一个 assertion 只能使用一次
+	ctx := r.Context()
+	sum := sha256.Sum256([]byte(JWTBearerGrantType + ":" + iss.Issuer + ":" + claims.ID))
+	ok, err := jtiStore.UseJTI(ctx, hex.EncodeToString(sum[:]), claims.ExpiresAt.Time)
+	if err != nil {
+		return WriteTokenError(w, err)
+	}
+	if !ok {
+		return WriteTokenError(w, errors.ErrInvalidGrant)
+	}
+
+	user, err := assertionUser(ctx, iss, claims.Subject)
+	if err != nil {
+		return WriteTokenError(w, err)
+	}
+	// 没有指定 scope 时使用签发方配置的 default_scope, 没有配置时拒绝 (RFC 6749 3.3)
+	requested := config.SplitScope(r.PostFormValue("scope"))
+	if len(requested) == 0 {
+		requested = iss.DefaultScope
+	}
+	scope := config.ScopeFilter(cli.GetID(), strings.Join(requested, " "))
+	if len(requested) == 0 || len(scope) != len(requested) {
+		return WriteTokenError(w, errors.ErrInvalidScope)
+	}
+
+	// 与 token exchange 一样不签发 refresh token, 需要时重新提交 assertion
+	ti, err := Mgr.GenerateAccessToken(ctx, oauth2.ClientCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:       cli.GetID(),
+		ClientSecret:   cli.GetSecret(),
+		UserID:         strconv.Itoa(int(user.ID)),
+		Scope:          config.JoinScope(scope),
+		AccessTokenExp: time.Hour * time.Duration(config.GetCfg().OAuth2.AccessTokenExp),
+	})
+	if err != nil {
+		return WriteTokenError(w, err)
+	}
+	return WriteToken(w, Srv.GetTokenData(ti), nil, http.StatusOK)
+}
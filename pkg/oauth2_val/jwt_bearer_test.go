@@ -0,0 +1,194 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func setupJWTBearer(t *testing.T) *ecdsa.PrivateKey {
+	setupRefresh(t)
+	if err := model.GlobalDB.AutoMigrate(model.User{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*model.User{
+		{Username: "alice", Email: "alice@example.com"},
+		{Username: "bob", Email: "shared@example.com"},
+		{Username: "carol", Email: "shared@example.com"},
+		{Username: "dave", Email: "dave@example.com", Disabled: true},
+	} {
+		if err := model.SaveUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := jwk.FromPublicKey("k1", "ES256", &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &jwk.Set{Keys: []jwk.JWK{k}}
+
+	// setupRefresh 结束时会恢复 cfg.OAuth2
+	cfg := config.GetCfg()
+	cfg.OAuth2.Issuer = testIssuer
+	scope := []config.Scope{{ID: "profile", Title: "profile"}, {ID: "email", Title: "email"}}
+	cfg.OAuth2.Client = []config.OAuth2Client{
+		{ID: "app", Secret: "secret", Scope: scope},
+		{ID: "other", Secret: "other_secret", Scope: scope},
+		{ID: "limited", Secret: "limited_secret", Scope: scope, GrantTypes: []string{"client_credentials"}},
+	}
+	cfg.OAuth2.JWTBearer.Issuers = []config.TrustedIssuer{
+		{Issuer: "https://idp.example.com", JWKS: jwk.FormatSet(set), Clients: []string{"app", "limited"}, DefaultScope: []string{"profile"}},
+		{Issuer: "https://mail.example.com", JWKS: jwk.FormatSet(set), SubjectMapping: oauth2_val.SubjectMappingEmail, Clients: []string{"app"}},
+	}
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signBearer(t *testing.T, key interface{}, iss, sub string, edit func(*jwt.RegisteredClaims)) string {
+	return signAssertion(t, jwt.SigningMethodES256, key, iss, func(c *jwt.RegisteredClaims) {
+		c.Subject = sub
+		if edit != nil {
+			edit(c)
+		}
+	})
+}
+
+// jwtBearer 使用 assertion 换取 token, 返回 token 的用户ID和错误码
+func jwtBearer(t *testing.T, clientID, secret, assertion, scope string) (string, string) {
+	ti, errCode := jwtBearerToken(t, clientID, secret, assertion, scope)
+	if ti == nil {
+		return "", errCode
+	}
+	return ti.GetUserID(), ""
+}
+
+func jwtBearerToken(t *testing.T, clientID, secret, assertion, scope string) (oauth2.TokenInfo, string) {
+	form := url.Values{"grant_type": {oauth2_val.JWTBearerGrantType}, "assertion": {assertion}, "scope": {scope}}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientID, secret)
+	w := httptest.NewRecorder()
+	if err := oauth2_val.HandleJWTBearerRequest(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+		Error        string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if resp.Error != "" {
+		return nil, resp.Error
+	}
+	if resp.RefreshToken != "" {
+		t.Error("jwt-bearer should not issue refresh token")
+	}
+	ti, _ := oauth2_val.LoadToken(context.Background(), resp.AccessToken, "access_token")
+	if ti == nil || ti.GetClientID() != clientID || ti.GetScope() != resp.Scope {
+		t.Fatal("unexpected token:", w.Body.String())
+	}
+	return ti, ""
+}
+
+func TestJWTBearer(t *testing.T) {
+	key := setupJWTBearer(t)
+
+	assertion := signBearer(t, key, "https://idp.example.com", "alice", nil)
+	if userID, errCode := jwtBearer(t, "app", "secret", assertion, ""); errCode != "" || userID != "1" {
+		t.Fatal("jwt-bearer failed:", userID, errCode)
+	}
+	// 同一个 assertion 不能重复使用
+	if _, errCode := jwtBearer(t, "app", "secret", assertion, ""); errCode != "invalid_grant" {
+		t.Error("replayed assertion should be rejected:", errCode)
+	}
+	// 按邮箱对应用户
+	if userID, errCode := jwtBearer(t, "app", "secret", signBearer(t, key, "https://mail.example.com", "alice@example.com", nil), "email"); errCode != "" || userID != "1" {
+		t.Error("email mapping failed:", userID, errCode)
+	}
+
+	// 没有指定 scope 时只授予签发方的 default_scope, 而不是客户端的全部 scope
+	if ti, errCode := jwtBearerToken(t, "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", nil), ""); errCode != "" || ti.GetScope() != "profile" {
+		t.Error("default scope should be used:", ti, errCode)
+	}
+	if ti, errCode := jwtBearerToken(t, "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", nil), "profile email"); errCode != "" || ti.GetScope() != "profile,email" {
+		t.Error("requested scope should be used:", ti, errCode)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cases := []struct {
+		name      string
+		client    string
+		secret    string
+		assertion string
+		scope     string
+		want      string
+	}{
+		{"client not allowed by issuer", "other", "other_secret", signBearer(t, key, "https://idp.example.com", "alice", nil), "", "invalid_grant"},
+		{"grant type not allowed", "limited", "limited_secret", signBearer(t, key, "https://idp.example.com", "alice", nil), "", "unauthorized_client"},
+		{"wrong secret", "app", "wrong", signBearer(t, key, "https://idp.example.com", "alice", nil), "", "invalid_client"},
+		{"unknown issuer", "app", "secret", signBearer(t, key, "https://evil.example.com", "alice", nil), "", "invalid_grant"},
+		{"wrong key", "app", "secret", signBearer(t, other, "https://idp.example.com", "alice", nil), "", "invalid_grant"},
+		{"unknown user", "app", "secret", signBearer(t, key, "https://idp.example.com", "nobody", nil), "", "invalid_grant"},
+		{"disabled user", "app", "secret", signBearer(t, key, "https://idp.example.com", "dave", nil), "", "invalid_grant"},
+		{"ambiguous email", "app", "secret", signBearer(t, key, "https://mail.example.com", "shared@example.com", nil), "", "invalid_grant"},
+		{"username on email issuer", "app", "secret", signBearer(t, key, "https://mail.example.com", "alice", nil), "", "invalid_grant"},
+		{"invalid scope", "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", nil), "profile admin", "invalid_scope"},
+		{"no scope without default", "app", "secret", signBearer(t, key, "https://mail.example.com", "alice@example.com", nil), "", "invalid_scope"},
+		{"missing jti", "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", func(c *jwt.RegisteredClaims) { c.ID = "" }), "", "invalid_grant"},
+		{"wrong audience", "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"https://other.example.com/token"}
+		}), "", "invalid_grant"},
+		{"expired", "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}), "", "invalid_grant"},
+		{"too long", "app", "secret", signBearer(t, key, "https://idp.example.com", "alice", func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(24 * time.Hour))
+		}), "", "invalid_grant"},
+		{"missing subject", "app", "secret", signBearer(t, key, "https://idp.example.com", "", nil), "", "invalid_grant"},
+		{"missing assertion", "app", "secret", "", "", "invalid_request"},
+	}
+	for _, c := range cases {
+		if _, errCode := jwtBearer(t, c.client, c.secret, c.assertion, c.scope); errCode != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, errCode, c.want)
+		}
+	}
+
+	// HMAC 签名的 assertion 不被接受, 即使知道公钥
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "https://idp.example.com",
+		Subject:   "alice",
+		Audience:  jwt.ClaimStrings{testIssuer},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		ID:        uuid.NewString(),
+	})
+	s, _ := token.SignedString([]byte("secret"))
+	if _, errCode := jwtBearer(t, "app", "secret", s, ""); errCode != "invalid_grant" {
+		t.Error("hmac assertion should be rejected:", errCode)
+	}
+}
@@ -0,0 +1,183 @@
+package oauth2_val
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"oauth2/config"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// SigningKey 加载好的签名密钥
+type SigningKey struct {
+	KID    string
+	Method jwt.SigningMethod
+	// 私钥, HS系列算法时为[]byte
+	Key interface{}
+	// 开始用于签名的时间
+	ActiveAt time.Time
+}
+
+// IsSymmetric 是否为对称密钥(HS系列)
+func (k *SigningKey) IsSymmetric() bool {
+	_, ok := k.Key.([]byte)
+	return ok
+}
+
+// Public 返回公钥, 对称密钥返回nil
+func (k *SigningKey) Public() crypto.PublicKey {
+	if s, ok := k.Key.(crypto.Signer); ok {
+		return s.Public()
+	}
+	return nil
+}
+
+// VerifyKey 验证签名使用的密钥
+func (k *SigningKey) VerifyKey() interface{} {
+	if k.IsSymmetric() {
+		return k.Key
+	}
+	return k.Public()
+}
+
+// Sign 签名并在header中写入kid
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	if k.KID != "" {
+		token.Header["kid"] = k.KID
+	}
+	return token.SignedString(k.Key)
+}
+
+// LoadSigningKey 加载签名密钥
+// HS系列使用配置中的secret, 其他从PEM文件中加载私钥
+// 没有配置生效时间的密钥从加载时开始生效
+func LoadSigningKey(cfg config.SigningKey) (*SigningKey, error) {
+	method := jwt.GetSigningMethod(cfg.Alg)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, fmt.Errorf("signing key %s: unsupported alg %s", cfg.KID, cfg.Alg)
+	}
+	if cfg.KID == "" {
+		return nil, fmt.Errorf("signing key: kid is required")
+	}
+
+	activeAt := time.Now()
+	if cfg.ActiveAt != "" {
+		t, err := time.Parse(time.RFC3339, cfg.ActiveAt)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %v", cfg.KID, err)
+		}
+		activeAt = t
+	}
+	k := &SigningKey{KID: cfg.KID, Method: method, ActiveAt: activeAt}
+
+	alg := method.Alg()
+	if strings.HasPrefix(alg, "HS") {
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("signing key %s: secret is required for %s", cfg.KID, alg)
+		}
+		k.Key = []byte(cfg.Secret)
+		return k, nil
+	}
+
+	data, err := os.ReadFile(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %v", cfg.KID, err)
+	}
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		k.Key, err = jwt.ParseRSAPrivateKeyFromPEM(data)
+	case strings.HasPrefix(alg, "ES"):
+		k.Key, err = jwt.ParseECPrivateKeyFromPEM(data)
+	case alg == "EdDSA":
+		k.Key, err = jwt.ParseEdPrivateKeyFromPEM(data)
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported alg %s", cfg.KID, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %v", cfg.KID, err)
+	}
+	return k, nil
+}
+
+// JWTAccessClaims access token 中的声明
+type JWTAccessClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"`
+	// 通过 token exchange 委托签发时的调用方
+	Act *Actor `json:"act,omitempty"`
+}
+
+// JWTAccessGenerate 使用当前签名密钥生成JWT格式的access token
+type JWTAccessGenerate struct{}
+
+// Token 生成access token和refresh token
+func (g *JWTAccessGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (string, string, error) {
+	ti := data.TokenInfo
+	claims := &JWTAccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.GetCfg().OAuth2.Issuer,
+			Audience:  jwt.ClaimStrings(tokenAudience(ti)),
+			Subject:   data.UserID,
+			IssuedAt:  jwt.NewNumericDate(data.CreateAt),
+			ExpiresAt: jwt.NewNumericDate(ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn())),
+		},
+		Scope: ti.GetScope(),
+		Act:   tokenActor(ti),
+	}
+
+	access, err := Keys.Current().Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+	refresh := ""
+	if isGenRefresh {
+		t := uuid.NewSHA1(uuid.Must(uuid.NewRandom()), []byte(access)).String()
+		refresh = base64.URLEncoding.EncodeToString([]byte(t))
+		refresh = strings.ToUpper(strings.TrimRight(refresh, "="))
+	}
+	return access, refresh, nil
+}
+
+// ParseAccessToken 验证access token的签名并解析声明
+// 根据header中的kid选择密钥, 已轮换下来但还在重叠期内的密钥依然可以验证
+func ParseAccessToken(access string) (*JWTAccessClaims, error) {
+	claims := &JWTAccessClaims{}
+	_, err := jwt.ParseWithClaims(access, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := Keys.Lookup(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected alg %s", token.Method.Alg())
+		}
+		return key.VerifyKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ValidationBearerToken 验证请求中的bearer token
+// 先验证JWT签名, 再从token存储中加载
+func ValidationBearerToken(r *http.Request) (oauth2.TokenInfo, error) {
+	access, ok := Srv.AccessTokenResolveHandler(r)
+	if !ok {
+		return nil, errors.ErrInvalidAccessToken
+	}
+	if _, err := ParseAccessToken(access); err != nil {
+		return nil, errors.ErrInvalidAccessToken
+	}
+	return Mgr.LoadAccessToken(r.Context(), access)
+}
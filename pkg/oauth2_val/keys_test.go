@@ -0,0 +1,58 @@
+package oauth2_val_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeECKey(t *testing.T) (string, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "es256.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	path, priv := writeECKey(t)
+	key, err := oauth2_val.LoadSigningKey(config.SigningKey{KID: "key-1", Alg: "ES256", PrivateKeyFile: path})
+	if err != nil {
+		t.Fatal("load signing key failed:", err)
+	}
+	if key.IsSymmetric() {
+		t.Error("ES256 key should not be symmetric")
+	}
+
+	signed, err := key.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		return &priv.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	if err != nil || !token.Valid || token.Header["kid"] != "key-1" {
+		t.Error("token should verify with public key:", err, token.Header)
+	}
+
+	if _, err := oauth2_val.LoadSigningKey(config.SigningKey{KID: "key-2", Alg: "HS256", PrivateKeyFile: path}); err == nil {
+		t.Error("HS256 key without secret should be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+package oauth2_val
+
+import (
+	"errors"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"sort"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keys 签名密钥集合
+var Keys *KeySet
+
+// KeySet 管理签名密钥的轮换
+//
+// 密钥按生效时间排序, 已生效的密钥中最新的一个用于签名;
+// 被新密钥替换下来的密钥在 tokenLifetime 内仍然可以验证, 并继续在jwks中公开,
+// 保证它签发的token全部过期之前都能被验证;
+// 还没到生效时间的密钥会提前在jwks中公开, 方便资源方提前缓存
+type KeySet struct {
+	keys          []*SigningKey
+	tokenLifetime time.Duration
+}
+
+// NewKeySet 创建密钥集合
+// tokenLifetime 为使用这些密钥签发的token的最长有效期
+func NewKeySet(keys []*SigningKey, tokenLifetime time.Duration) (*KeySet, error) {
+	sorted := make([]*SigningKey, len(keys))
+	copy(sorted, keys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActiveAt.Before(sorted[j].ActiveAt)
+	})
+
+	seen := make(map[string]bool)
+	for _, k := range sorted {
+		if seen[k.KID] {
+			return nil, errors.New("duplicate signing key kid: " + k.KID)
+		}
+		seen[k.KID] = true
+	}
+
+	s := &KeySet{keys: sorted, tokenLifetime: tokenLifetime}
+	if s.Current() == nil {
+		return nil, errors.New("no active signing key")
+	}
+	return s, nil
+}
+
+// Current 当前用于签名的密钥
+func (s *KeySet) Current() *SigningKey {
+	now := time.Now()
+	var current *SigningKey
+	for _, k := range s.keys {
+		if k.ActiveAt.After(now) {
+			break
+		}
+		current = k
+	}
+	return current
+}
+
+// retiredAt 密钥被下一个密钥替换的时间, 还没有被替换时返回false
+func (s *KeySet) retiredAt(i int, now time.Time) (time.Time, bool) {
+	if i+1 < len(s.keys) && !s.keys[i+1].ActiveAt.After(now) {
+		return s.keys[i+1].ActiveAt, true
+	}
+	return time.Time{}, false
+}
+
+// verifiable 密钥是否已生效且还在验证期内
+func (s *KeySet) verifiable(i int, now time.Time) bool {
+	if s.keys[i].ActiveAt.After(now) {
+		return false
+	}
+	if t, ok := s.retiredAt(i, now); ok {
+		return t.Add(s.tokenLifetime).After(now)
+	}
+	return true
+}
+
+// Lookup 根据kid查找可以用来验证签名的密钥
+func (s *KeySet) Lookup(kid string) *SigningKey {
+	now := time.Now()
+	for i, k := range s.keys {
+		if k.KID == kid && s.verifiable(i, now) {
+			return k
+		}
+	}
+	return nil
+}
+
+// Published 需要公开的密钥: 待生效的, 当前的, 以及还在验证期内的
+func (s *KeySet) Published() []*SigningKey {
+	now := time.Now()
+	var keys []*SigningKey
+	for i, k := range s.keys {
+		if k.ActiveAt.After(now) || s.verifiable(i, now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// JWKs 公开的非对称密钥的公钥
+func (s *KeySet) JWKs() (jwk.Set, error) {
+	set := jwk.Set{Keys: make([]jwk.JWK, 0)}
+	for _, k := range s.Published() {
+		if k.IsSymmetric() {
+			continue
+		}
+		v, err := jwk.FromPublicKey(k.KID, k.Method.Alg(), k.Public())
+		if err != nil {
+			return set, err
+		}
+		set.Keys = append(set.Keys, v)
+	}
+	return set, nil
+}
+
+// SetupSigningKeys 根据配置加载签名密钥, 离线验证token时可以单独调用
+func SetupSigningKeys() {
+	var keys []*SigningKey
+	// jwt_signed_key 作为最早的密钥(kid为空), 配置新密钥后在重叠期内依然可以验证
+	if v := config.GetCfg().OAuth2.JWTSignedKey; v != "" {
+		keys = append(keys, &SigningKey{Method: jwt.SigningMethodHS512, Key: []byte(v)})
+	}
+	for _, v := range config.GetCfg().OAuth2.SigningKeys {
+		k, err := LoadSigningKey(v)
+		if err != nil {
+			panic(err)
+		}
+		keys = append(keys, k)
+	}
+
+	var err error
+	Keys, err = NewKeySet(keys, keyOverlap())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// minKeyOverlap 被替换下来的密钥最少继续验证的时间
+const minKeyOverlap = 2 * time.Hour
+
+// keyOverlap 被替换下来的密钥继续验证和公开的时间
+// 取各授权方式中最长的 access token 有效期: access_token_exp 用于授权码、设备授权、token exchange 和 jwt-bearer,
+// 密码和客户端模式使用 manage 的默认配置; access_token_exp 为0时至少保留 minKeyOverlap
+func keyOverlap() time.Duration {
+	overlap := minKeyOverlap
+	for _, exp := range []time.Duration{
+		time.Hour * time.Duration(config.GetCfg().OAuth2.AccessTokenExp),
+		manage.DefaultPasswordTokenCfg.AccessTokenExp,
+		manage.DefaultClientTokenCfg.AccessTokenExp,
+		manage.DefaultImplicitTokenCfg.AccessTokenExp,
+	} {
+		if exp > overlap {
+			overlap = exp
+		}
+	}
+	return overlap
+}
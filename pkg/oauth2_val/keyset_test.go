@@ -0,0 +1,86 @@
+package oauth2_val_test
+
+import (
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func hsKey(kid string, activeAt time.Time) *oauth2_val.SigningKey {
+	return &oauth2_val.SigningKey{KID: kid, Method: jwt.SigningMethodHS256, Key: []byte(kid), ActiveAt: activeAt}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	now := time.Now()
+	keys := []*oauth2_val.SigningKey{
+		hsKey("next", now.Add(time.Hour)),
+		hsKey("current", now.Add(-time.Hour)),
+		hsKey("retired", now.Add(-5*time.Hour)),
+		hsKey("", time.Time{}),
+	}
+	s, err := oauth2_val.NewKeySet(keys, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k := s.Current(); k == nil || k.KID != "current" {
+		t.Fatal("current key should be the latest active one:", k)
+	}
+	// 被替换1小时, 仍在2小时的重叠期内
+	if s.Lookup("retired") == nil {
+		t.Error("retired key should still verify during overlap window")
+	}
+	// 被替换5小时, 已经超过重叠期
+	if s.Lookup("") != nil {
+		t.Error("legacy key should no longer verify")
+	}
+	if s.Lookup("next") != nil {
+		t.Error("scheduled key should not verify before it becomes active")
+	}
+
+	var published []string
+	for _, k := range s.Published() {
+		published = append(published, k.KID)
+	}
+	if len(published) != 3 || published[0] != "retired" || published[1] != "current" || published[2] != "next" {
+		t.Error("unexpected published keys:", published)
+	}
+}
+
+func TestKeySetErrors(t *testing.T) {
+	now := time.Now()
+	if _, err := oauth2_val.NewKeySet([]*oauth2_val.SigningKey{hsKey("a", now.Add(time.Hour))}, time.Hour); err == nil {
+		t.Error("key set without active key should be rejected")
+	}
+	if _, err := oauth2_val.NewKeySet([]*oauth2_val.SigningKey{hsKey("a", now), hsKey("a", now)}, time.Hour); err == nil {
+		t.Error("duplicate kid should be rejected")
+	}
+}
+
+func TestSigningKeysOverlap(t *testing.T) {
+	cfg := config.GetCfg()
+	old := cfg.OAuth2
+	t.Cleanup(func() { cfg.OAuth2 = old })
+	cfg.OAuth2.JWTSignedKey = "legacy"
+	// 新密钥生效90分钟, 密码和客户端模式签发的 token 有效期为2小时, 旧密钥需要继续验证
+	cfg.OAuth2.SigningKeys = []config.SigningKey{
+		{KID: "key-1", Alg: "HS256", Secret: "secret", ActiveAt: time.Now().Add(-90 * time.Minute).Format(time.RFC3339)},
+	}
+	for _, exp := range []int{0, 1} {
+		cfg.OAuth2.AccessTokenExp = exp
+		oauth2_val.SetupSigningKeys()
+		if oauth2_val.Keys.Lookup("") == nil {
+			t.Errorf("access_token_exp=%d: retired key should verify tokens of every grant", exp)
+		}
+	}
+	// 超过最长的有效期之后移除
+	cfg.OAuth2.AccessTokenExp = 1
+	cfg.OAuth2.SigningKeys[0].ActiveAt = time.Now().Add(-3 * time.Hour).Format(time.RFC3339)
+	oauth2_val.SetupSigningKeys()
+	if oauth2_val.Keys.Lookup("") != nil {
+		t.Error("retired key should be removed after the longest token lifetime")
+	}
+}
@@ -11,13 +11,11 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/errors"
-	"github.com/go-oauth2/oauth2/v4/generates"
 	"github.com/go-oauth2/oauth2/v4/manage"
-	"github.com/go-oauth2/oauth2/v4/models"
 	"github.com/go-oauth2/oauth2/v4/server"
 	"github.com/go-oauth2/oauth2/v4/store"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 // Srv 是 OAuth2 Server 的核心实例，负责处理授权码、令牌等 HTTP 请求。
@@ -26,6 +24,9 @@ var Srv *server.Server
 // Mgr 是 OAuth2 的管理器，负责令牌存储、客户端信息、Token 配置等资源管理。
 var Mgr *manage.Manager
 
+// TokenStore 当前使用的 token 存储, 管理接口通过它列出 token
+var TokenStore oauth2.TokenStore
+
 func Setup(ctx context.Context) {
 	// 创建默认管理器，负责 token 管理、客户端存储、配置等
 	Mgr = manage.NewDefaultManager()
@@ -35,49 +36,82 @@ func Setup(ctx context.Context) {
 		RefreshTokenExp:   time.Hour * 24 * 3,
 		IsGenerateRefresh: true,
 	})
+	// 每次刷新都签发新的 refresh token, 旧的 access/refresh token 立即作废
+	// 旧的 refresh token 再次使用时的处理见 refreshTokenResolveHandler
+	Mgr.SetRefreshTokenCfg(&manage.RefreshingConfig{
+		IsGenerateRefresh:  true,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
+	})
 	switch config.GetCfg().OAuth2.TokenStore {
 	case "memory":
-		Mgr.MustTokenStorage(store.NewMemoryTokenStore())
+		TokenStore = newMemoryTokenStore()
+		refreshFamilies = storage.NewMemoryRefreshFamilyStore()
+		deviceStore = storage.NewMemoryDeviceStore()
+		jtiStore = storage.NewMemoryJTIStore()
 	case "redis":
-		// Mgr.MustTokenStorage(store.NewRedisTokenStore())
-	case "mysql":
+		tokenStore := storage.NewRedisTokenStore(model.Redis(), config.GetCfg().Redis.Default.KeyPrefix)
+		TokenStore, refreshFamilies, deviceStore, jtiStore = tokenStore, tokenStore, tokenStore, tokenStore
+	case "db", "mysql":
 		sqlDb, err := model.GlobalDB.DB()
 		if err != nil {
 			panic(err)
 		}
-		tokenStore := storage.NewMySQLTokenStore(sqlDb, "access_tokens")
+		tokenStore := storage.NewSQLTokenStore(sqlDb, config.GetCfg().DB.Default.Type, "access_tokens")
 		tokenStore.StartTicker(ctx, 5*time.Second)
 		// 创建表
 		if err := tokenStore.CreateTable(); err != nil {
 			log.Fatal("Failed to create token table:", err)
 		}
-		Mgr.MustTokenStorage(tokenStore, nil)
+		TokenStore, refreshFamilies, deviceStore, jtiStore = tokenStore, tokenStore, tokenStore, tokenStore
 	default:
-		Mgr.MustTokenStorage(store.NewMemoryTokenStore())
+		TokenStore = newMemoryTokenStore()
+		refreshFamilies = storage.NewMemoryRefreshFamilyStore()
+		deviceStore = storage.NewMemoryDeviceStore()
+		jtiStore = storage.NewMemoryJTIStore()
 	}
+	Mgr.MapTokenStorage(&rotatingTokenStore{TokenStore: TokenStore, families: refreshFamilies})
 	// 配置 JWT Access Token 的生成器
-	Mgr.MapAccessGenerate(generates.NewJWTAccessGenerate("", []byte(config.GetCfg().OAuth2.JWTSignedKey), jwt.SigningMethodHS512))
-	// 注册 Client 信息（可以改成 DB/配置中心）
-	clientStore := store.NewClientStore()
-	for _, v := range config.GetCfg().OAuth2.Client {
-		err := clientStore.Set(v.ID, &models.Client{
-			ID:     v.ID,
-			Secret: v.Secret,
-			Domain: v.Domain,
-		})
-		if err != nil {
-			return
-		}
-	}
-	Mgr.MapClientStorage(clientStore)
+	// 配置了 signing_keys 时使用非对称密钥签名, 否则使用 jwt_signed_key
+	SetupSigningKeys()
+	Mgr.MapAccessGenerate(&JWTAccessGenerate{})
+	// 客户端存储在数据库中, 配置文件中的客户端在 model.Setup 时已同步
+	Mgr.MapClientStorage(storage.NewClientStore())
+	// redirect_uri 在 AuthorizeHandler 中已按客户端的 redirect_uris 精确校验,
+	// 换取 token 时 Mgr 会检查 redirect_uri 与授权码中记录的一致, 这里不再按 domain 校验
+	Mgr.SetValidateURIHandler(func(baseURI, redirectURI string) error { return nil })
+	// 记录 OpenID Connect 需要的 nonce 和 auth_time
+	Mgr.SetExtractExtensionHandler(extractExtension)
 
 	// 创建 OAuth2 Server 实例并挂载各类 Handler
 	Srv = server.NewServer(server.NewConfig(), Mgr)
+	Srv.SetClientInfoHandler(clientInfoHandler)                       // 从请求中获取 client_id/client_secret，支持 basic auth、表单和 client_assertion
+	Srv.SetClientAuthorizedHandler(clientAuthorizedHandler)           // 检查客户端是否允许使用该授权方式(grant_types)
 	Srv.SetPasswordAuthorizationHandler(passwordAuthorizationHandler) // 处理 “password” 授权模式（资源所有者密码凭证）时的用户验证逻辑，当客户端提交用户名 + 密码换取 token 时调用。
 	Srv.SetUserAuthorizationHandler(userAuthorizeHandler)             // 处理 “authorization_code” 等需要用户确认授权的流程，用来检查当前是否已有登录用户；如果没有，通常重定向到登录页
 	Srv.SetAuthorizeScopeHandler(authorizeScopeHandler)               // 当用户勾选/确认授权范围（scope）后，对比客户端注册的合法 scope，过滤非法项，并返回最终生效的 scope
 	Srv.SetInternalErrorHandler(internalErrorHandler)                 // OAuth2 server 内部出错（例如存储、生成 token 时异常）时的统一兜底处理，可以记录日志、定制返回
 	Srv.SetResponseErrorHandler(responseErrorHandler)                 // 当 OAuth2 协议对外响应发生错误（如无效客户端、无效授权）时的处理，可用于统一日志或格式化错误输出
+	Srv.SetExtensionFieldsHandler(extensionFieldsHandler)             // 在 token 响应中附加额外字段，申请了 openid 时返回 id_token
+	Srv.SetRefreshTokenResolveHandler(refreshTokenResolveHandler)     // 读取 refresh token，已经用过的 refresh token 再次使用时撤销同一次授权的全部 token
+	Srv.SetRefreshingValidationHandler(refreshingValidationHandler)   // 签发新 token 之前把 refresh token 标记为已使用，并发刷新时只有一个成功
+}
+
+// GrantTypesSupported token 端点支持的授权方式, 包括在 TokenHandler 中单独处理的
+func GrantTypesSupported() []string {
+	var result []string
+	for _, gt := range Srv.Config.AllowedGrantTypes {
+		result = append(result, string(gt))
+	}
+	return append(result, DeviceCodeGrantType, TokenExchangeGrantType, JWTBearerGrantType)
+}
+
+func newMemoryTokenStore() oauth2.TokenStore {
+	ts, err := store.NewMemoryTokenStore()
+	if err != nil {
+		panic(err)
+	}
+	return ts
 }
 
 // oauth2进行密码认证的方式
@@ -90,12 +124,24 @@ func passwordAuthorizationHandler(ctx context.Context, clientID, username, passw
 }
 
 func userAuthorizeHandler(w http.ResponseWriter, r *http.Request) (userID string, err error) {
-	v, _ := session.Get(r, "LoggedInUserID")
-	if v == nil {
-		if r.Form == nil {
-			r.ParseForm()
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	if err = validatePrompt(r.Form); err != nil {
+		return
+	}
+	sso, err := currentLogin(r)
+	if err != nil {
+		return
+	}
+	if sso == nil {
+		// prompt=none 时不能显示登录页面
+		if hasPrompt(r.Form, PromptNone) {
+			return "", ErrLoginRequired
 		}
-		session.Set(w, r, "RequestForm", r.Form)
+		session.Set(w, r, "RequestForm", loginForm(r.Form))
+		// 登录后回到 /authorize, 不要跳到之前没有完成的 /device
+		session.Delete(w, r, "LoginRedirect")
 
 		// 登录页面
 		// 最终会把userId写进session(LoggedInUserID)
@@ -104,29 +150,105 @@ func userAuthorizeHandler(w http.ResponseWriter, r *http.Request) (userID string
 		w.WriteHeader(http.StatusFound)
 		return
 	}
-	userID = v.(string)
+	userID = sso.UserID
+
+	// 登录之后, 没有同意过本次申请的scope时跳转到确认页面
+	// 确认页面提交后会在session中记录结果, 再跳回来
+	clientID := r.Form.Get("client_id")
+	if v, _ := session.Get(r, "ConsentDenied"); v == clientID {
+		session.Delete(w, r, "ConsentDenied")
+		return "", errors.ErrAccessDenied
+	}
+	if v, _ := session.Get(r, "ConsentGranted"); v == clientID {
+		session.Delete(w, r, "ConsentGranted")
+		touchSSO(r, sso, clientID)
+		return
+	}
+	need, err := NeedsConsent(r.Context(), userID, clientID, r.Form.Get("scope"))
+	if err != nil {
+		return "", err
+	}
+	// prompt=consent 要求重新确认, 自己的应用除外
+	if !need && hasPrompt(r.Form, PromptConsent) {
+		cli := config.GetOAuth2Client(clientID)
+		need = cli != nil && !cli.FirstParty
+	}
+	if need {
+		if hasPrompt(r.Form, PromptNone) {
+			return "", ErrConsentRequired
+		}
+		session.Set(w, r, "RequestForm", r.Form)
+		w.Header().Set("Location", "/consent")
+		w.WriteHeader(http.StatusFound)
+		return "", nil
+	}
+	touchSSO(r, sso, clientID)
 	return
 }
 
-// 场景:在登录页面勾选所要访问的资源范围
-// 根据client注册的scope,过滤表单中非法scope
+// touchSSO 记录通过这次登录授权的客户端, 失败不影响授权
+func touchSSO(r *http.Request, sso *model.SSOSession, clientID string) {
+	if err := session.TouchSSO(r.Context(), sso, clientID); err != nil {
+		log.Println("Touch sso session error:", err)
+	}
+}
+
+// 场景:在确认页面勾选所要访问的资源范围
+// 根据client注册的scope,过滤表单中非法scope, 再过滤掉用户没有同意的scope
 // HandleAuthorizeRequest中调用
 // set scope for the access token
 func authorizeScopeHandler(w http.ResponseWriter, r *http.Request) (scope string, err error) {
 	if r.Form == nil {
 		r.ParseForm()
 	}
-	s := config.ScopeFilter(r.Form.Get("client_id"), r.Form.Get("scope"))
+	clientID := r.Form.Get("client_id")
+	s := config.ScopeFilter(clientID, r.Form.Get("scope"))
 	if s == nil {
 		err = errors.New("无效的权限范围")
 		return
 	}
+	// 只保留用户在确认页面上同意的scope
+	// 用户以登录会话为准, 会话被终止后 session 中残留的用户ID不再有效
+	sso, err := session.CurrentSSO(r)
+	if err != nil {
+		return
+	}
+	if sso == nil {
+		err = errors.ErrAccessDenied
+		return
+	}
+	if s, err = ConsentedScope(r.Context(), sso.UserID, clientID, s); err != nil {
+		return
+	}
 	scope = config.JoinScope(s)
 	return
 }
 
+// 提供了 client_assertion 时验证JWT, 否则优先使用 basic auth, 没有时从表单获取
+// 公开客户端只会在表单中提供 client_id
+func clientInfoHandler(r *http.Request) (clientID, clientSecret string, err error) {
+	if r.PostFormValue("client_assertion") != "" || r.PostFormValue("client_assertion_type") != "" {
+		return clientAssertionHandler(r)
+	}
+	return clientSecretHandler(r)
+}
+
+// clientAuthorizedHandler 客户端没有配置 grant_types 时不限制
+func clientAuthorizedHandler(clientID string, grant oauth2.GrantType) (allowed bool, err error) {
+	cli := config.GetOAuth2Client(clientID)
+	if cli == nil {
+		return false, errors.ErrInvalidClient
+	}
+	return cli.AllowsGrantType(string(grant)), nil
+}
+
 func internalErrorHandler(err error) (re *errors.Response) {
 	log.Println("Internal Error:", err.Error())
+	// 授权码中有 code_challenge 但没有提供 code_verifier
+	if err == errors.ErrMissingCodeVerifier {
+		re = errors.NewResponse(errors.ErrInvalidGrant, http.StatusBadRequest)
+		re.Description = "code_verifier is missing"
+	}
 	return
 }
 
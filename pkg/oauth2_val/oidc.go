@@ -0,0 +1,162 @@
+package oauth2_val
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/session"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect 相关的scope
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+)
+
+// ScopesSupported discovery 文档中公开的 scope, 没有配置时为 OpenID Connect 的标准 scope
+func ScopesSupported() []string {
+	if scopes := config.GetCfg().OAuth2.ScopesSupported; len(scopes) > 0 {
+		return scopes
+	}
+	return []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopePhone}
+}
+
+// ErrIDTokenKeyRequired 没有 secret 的客户端(公开客户端和 private_key_jwt)不能以 HS256 签名 id_token,
+// 需要配置非对称的 signing_keys
+var ErrIDTokenKeyRequired = errors.New("id_token requires an asymmetric signing key for clients without secret")
+
+// IDTokenClaims id_token 中的声明
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	AuthTime int64  `json:"auth_time,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+}
+
+// extractExtension 把授权请求中的nonce和用户登录时间记录到token的扩展字段中
+// 授权码兑换token时扩展字段会被带到新的token上
+func extractExtension(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = make(url.Values)
+	}
+	if r := tgr.Request; r != nil {
+		// token exchange 等预先确定的字段
+		for k, v := range requestExtension(r) {
+			ext[k] = v
+		}
+		if nonce := r.FormValue("nonce"); nonce != "" && ext.Get("nonce") == "" {
+			ext.Set("nonce", nonce)
+		}
+		if ext.Get("auth_time") == "" {
+			if v, _ := session.Get(r, "AuthTime"); v != nil {
+				ext.Set("auth_time", strconv.FormatInt(v.(int64), 10))
+			}
+		}
+	}
+	// 密码模式等没有经过登录页面的, 以签发时间作为登录时间
+	if ext.Get("auth_time") == "" && tgr.UserID != "" {
+		ext.Set("auth_time", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	ti.SetExtension(ext)
+}
+
+// extensionFieldsHandler 申请了openid时在token响应中附加id_token
+// 无法安全签名时(见 GenerateIDToken)不返回id_token
+func extensionFieldsHandler(ti oauth2.TokenInfo) map[string]interface{} {
+	if ti.GetUserID() == "" || !config.HasScope(ti.GetScope(), ScopeOpenID) {
+		return nil
+	}
+	idToken, err := GenerateIDToken(context.Background(), ti)
+	if err != nil {
+		log.Println("Generate id_token error:", err)
+		return nil
+	}
+	return map[string]interface{}{"id_token": idToken}
+}
+
+// GenerateIDToken 根据token信息生成id_token
+// 配置了非对称密钥时使用当前签名密钥, 否则使用client secret以HS256签名
+// secret 为空时任何人都可以伪造签名, 返回 ErrIDTokenKeyRequired
+func GenerateIDToken(ctx context.Context, ti oauth2.TokenInfo) (string, error) {
+	key := Keys.Current()
+	if key.IsSymmetric() {
+		cli, err := Mgr.GetClient(ctx, ti.GetClientID())
+		if err != nil {
+			return "", err
+		}
+		if cli.GetSecret() == "" {
+			return "", ErrIDTokenKeyRequired
+		}
+		key = &SigningKey{Method: jwt.SigningMethodHS256, Key: []byte(cli.GetSecret())}
+	}
+
+	now := time.Now()
+	claims := &IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.GetCfg().OAuth2.Issuer,
+			Subject:   ti.GetUserID(),
+			Audience:  jwt.ClaimStrings{ti.GetClientID()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ti.GetAccessExpiresIn())),
+		},
+	}
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok {
+		ext := eti.GetExtension()
+		claims.Nonce = ext.Get("nonce")
+		claims.AuthTime, _ = strconv.ParseInt(ext.Get("auth_time"), 10, 64)
+	}
+
+	return key.Sign(claims)
+}
+
+// UserInfoClaims 根据token中的scope返回用户信息
+// profile -> preferred_username, picture
+// email   -> email
+// phone   -> phone_number
+func UserInfoClaims(user *model.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": strconv.Itoa(int(user.ID)),
+	}
+	if config.HasScope(scope, ScopeProfile) {
+		claims["preferred_username"] = user.Username
+		claims["picture"] = user.Avatar
+	}
+	if config.HasScope(scope, ScopeEmail) {
+		claims["email"] = user.Email
+	}
+	if config.HasScope(scope, ScopePhone) {
+		claims["phone_number"] = user.Phone
+	}
+	return claims
+}
+
+// IDTokenSigningAlg id_token 使用的签名算法
+func IDTokenSigningAlg() string {
+	if key := Keys.Current(); !key.IsSymmetric() {
+		return key.Method.Alg()
+	}
+	return jwt.SigningMethodHS256.Alg()
+}
+
+// ValidationUserInfoRequest 验证/userinfo请求携带的access token
+func ValidationUserInfoRequest(r *http.Request) (oauth2.TokenInfo, error) {
+	ti, err := ValidationBearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if !config.HasScope(ti.GetScope(), ScopeOpenID) || ti.GetUserID() == "" {
+		return nil, ErrInsufficientScope
+	}
+	return ti, nil
+}
@@ -0,0 +1,125 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"oauth2/pkg/session"
+	"strings"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func setupOIDC(t *testing.T) {
+	setupRefresh(t)
+	// 签发 token 时会读取 session 中的登录时间
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	session.Setup(ctx)
+
+	cfg := config.GetCfg()
+	cfg.OAuth2.Issuer = testIssuer
+	scope := []config.Scope{{ID: "openid", Title: "openid"}, {ID: "profile", Title: "profile"}}
+	cfg.OAuth2.Client = []config.OAuth2Client{
+		{ID: "app", Secret: "secret", Scope: scope},
+		{ID: "spa", Public: true, Scope: scope},
+	}
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// issueOIDCToken 签发带 nonce 的 token, 返回 token 响应中的 id_token
+func issueOIDCToken(t *testing.T, clientID, secret string) (oauth2.TokenInfo, string) {
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{"nonce": {"n-0S6"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(context.Background(), oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     clientID,
+		ClientSecret: secret,
+		UserID:       "1",
+		Scope:        "openid,profile",
+		Request:      r,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, _ := oauth2_val.Srv.GetTokenData(ti)["id_token"].(string)
+	return ti, idToken
+}
+
+func TestIDToken(t *testing.T) {
+	setupOIDC(t)
+
+	ti, idToken := issueOIDCToken(t, "app", "secret")
+	claims := &oauth2_val.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("secret"), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithIssuer(testIssuer), jwt.WithAudience("app"), jwt.WithSubject("1"))
+	if err != nil {
+		t.Fatal("id_token should verify with client secret:", err)
+	}
+	// nonce 来自授权请求, 没有经过登录页面时以签发时间作为登录时间
+	if claims.Nonce != "n-0S6" || claims.AuthTime != ti.GetAccessCreateAt().Unix() {
+		t.Error("unexpected nonce or auth_time:", claims.Nonce, claims.AuthTime)
+	}
+
+	// 公开客户端没有 secret, 不能以 HS256 签名
+	ti, idToken = issueOIDCToken(t, "spa", "")
+	if idToken != "" {
+		t.Error("public client should not get HS256 id_token")
+	}
+	if _, err := oauth2_val.GenerateIDToken(context.Background(), ti); err != oauth2_val.ErrIDTokenKeyRequired {
+		t.Error("unexpected error:", err)
+	}
+
+	// 配置了非对称密钥后公开客户端也可以拿到 id_token
+	path, priv := writeECKey(t)
+	config.GetCfg().OAuth2.SigningKeys = []config.SigningKey{{KID: "key-1", Alg: "ES256", PrivateKeyFile: path}}
+	oauth2_val.SetupSigningKeys()
+	if _, idToken = issueOIDCToken(t, "spa", ""); idToken == "" {
+		t.Fatal("missing id_token with asymmetric key")
+	}
+	_, err = jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		return &priv.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience("spa"))
+	if err != nil {
+		t.Error("id_token should verify with public key:", err)
+	}
+}
+
+func TestUserInfoClaims(t *testing.T) {
+	u := &model.User{ID: 7, Username: "alice", Avatar: "a.png", Email: "alice@example.com", Phone: "123"}
+	claims := oauth2_val.UserInfoClaims(u, "openid")
+	if len(claims) != 1 || claims["sub"] != "7" {
+		t.Error("openid only should return sub:", claims)
+	}
+	claims = oauth2_val.UserInfoClaims(u, "openid profile,email")
+	if claims["preferred_username"] != "alice" || claims["picture"] != "a.png" || claims["email"] != "alice@example.com" {
+		t.Error("unexpected profile or email claims:", claims)
+	}
+	if _, ok := claims["phone_number"]; ok {
+		t.Error("phone_number requires phone scope")
+	}
+}
+
+func TestScopesSupported(t *testing.T) {
+	cfg := config.GetCfg()
+	old := cfg.OAuth2.ScopesSupported
+	t.Cleanup(func() { cfg.OAuth2.ScopesSupported = old })
+
+	// 没有配置时只公开标准 scope, 不包括客户端配置的 admin 等内部 scope
+	cfg.OAuth2.ScopesSupported = nil
+	if got := strings.Join(oauth2_val.ScopesSupported(), " "); got != "openid profile email phone" {
+		t.Error("unexpected default scopes:", got)
+	}
+	cfg.OAuth2.ScopesSupported = []string{"openid", "orders"}
+	if got := strings.Join(oauth2_val.ScopesSupported(), " "); got != "openid orders" {
+		t.Error("unexpected configured scopes:", got)
+	}
+}
@@ -0,0 +1,50 @@
+package oauth2_val
+
+import (
+	"net/http"
+	"oauth2/config"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// RequirePKCE 客户端是否必须使用PKCE
+// 公开客户端总是需要
+func RequirePKCE(clientID string) bool {
+	cli := config.GetOAuth2Client(clientID)
+	return cli != nil && (cli.Public || cli.RequirePKCE)
+}
+
+// ValidationAuthorizePKCE 检查授权请求中的 code_challenge 和 code_challenge_method
+// 在跳转登录页面之前调用, 避免用户登录之后才发现请求无效
+func ValidationAuthorizePKCE(r *http.Request) error {
+	cc := r.FormValue("code_challenge")
+	if cc == "" {
+		if RequirePKCE(r.FormValue("client_id")) {
+			return errors.ErrCodeChallengeRquired
+		}
+		return nil
+	}
+	if len(cc) < 43 || len(cc) > 128 {
+		return errors.ErrInvalidCodeChallengeLen
+	}
+	if ccm := r.FormValue("code_challenge_method"); ccm != "" && oauth2.CodeChallengeMethod(ccm).String() == "" {
+		return errors.ErrUnsupportedCodeChallengeMethod
+	}
+	return nil
+}
+
+// ValidationTokenPKCE 要求PKCE的客户端用授权码换token时必须提供 code_verifier
+// code_verifier 和 code_challenge 是否匹配由 Mgr 验证
+func ValidationTokenPKCE(r *http.Request) error {
+	if r.FormValue("grant_type") != oauth2.AuthorizationCode.String() {
+		return nil
+	}
+	// 这里只需要 client_id, 客户端认证交给 Srv 处理
+	// client assertion 只能验证一次, 不能在这里调用 ClientInfoHandler
+	clientID := requestClientID(r)
+	if RequirePKCE(clientID) && r.FormValue("code_verifier") == "" {
+		return ErrCodeVerifierRequired
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package oauth2_val
+
+import (
+	"net/http"
+	"net/url"
+	"oauth2/pkg/model"
+	"oauth2/pkg/session"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// 授权请求的 prompt 参数 (OpenID Connect Core 3.1.2.1), 多个值用空格分隔
+const (
+	// PromptNone 不显示任何页面, 需要登录或确认时返回错误
+	PromptNone = "none"
+	// PromptLogin 要求重新登录
+	PromptLogin = "login"
+	// PromptConsent 要求重新确认授权
+	PromptConsent = "consent"
+	// PromptSelectAccount 选择账号, 这里按重新登录处理
+	PromptSelectAccount = "select_account"
+)
+
+// PromptValues 支持的prompt
+var PromptValues = []string{PromptNone, PromptLogin, PromptConsent, PromptSelectAccount}
+
+func hasPrompt(form url.Values, prompt string) bool {
+	return contains(strings.Fields(form.Get("prompt")), prompt)
+}
+
+// validatePrompt none 不能和其他值一起使用
+func validatePrompt(form url.Values) error {
+	prompts := strings.Fields(form.Get("prompt"))
+	if contains(prompts, PromptNone) && len(prompts) > 1 {
+		return ErrInvalidPrompt
+	}
+	return nil
+}
+
+// currentLogin 当前浏览器的登录会话, 需要(重新)登录时返回nil
+// 没有登录, 登录会话已被终止, 要求重新登录, 或者登录时间超过了 max_age
+func currentLogin(r *http.Request) (*model.SSOSession, error) {
+	sso, err := session.CurrentSSO(r)
+	if err != nil || sso == nil {
+		return nil, err
+	}
+	if hasPrompt(r.Form, PromptLogin) || hasPrompt(r.Form, PromptSelectAccount) {
+		return nil, nil
+	}
+	v := r.Form.Get("max_age")
+	if v == "" {
+		return sso, nil
+	}
+	maxAge, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || maxAge < 0 {
+		return nil, errors.ErrInvalidRequest
+	}
+	authTime, _ := session.Get(r, "AuthTime")
+	t, _ := authTime.(int64)
+	if time.Now().Unix()-t > maxAge {
+		return nil, nil
+	}
+	return sso, nil
+}
+
+// loginForm 跳转到登录页面时保存的授权请求
+// 去掉 prompt 中的 login/select_account 和 max_age, 重新登录之后不会再次跳到登录页面
+func loginForm(form url.Values) url.Values {
+	result := make(url.Values, len(form))
+	for k, v := range form {
+		result[k] = v
+	}
+	var prompts []string
+	for _, p := range strings.Fields(form.Get("prompt")) {
+		if p != PromptLogin && p != PromptSelectAccount {
+			prompts = append(prompts, p)
+		}
+	}
+	if len(prompts) > 0 {
+		result.Set("prompt", strings.Join(prompts, " "))
+	} else {
+		result.Del("prompt")
+	}
+	result.Del("max_age")
+	return result
+}
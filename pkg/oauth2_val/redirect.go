@@ -0,0 +1,70 @@
+package oauth2_val
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// ValidationRedirectURI 按客户端配置的 redirect_uris 校验授权请求中的回调地址
+// 请求中没有 redirect_uri 且只配置了一个地址时, 把该地址写入表单
+func ValidationRedirectURI(r *http.Request) error {
+	cli := config.GetOAuth2Client(r.FormValue("client_id"))
+	if cli == nil {
+		return errors.ErrInvalidClient
+	}
+	redirectURI := r.FormValue("redirect_uri")
+	if redirectURI == "" {
+		if len(cli.RedirectURIs) != 1 {
+			return errors.ErrInvalidRedirectURI
+		}
+		r.Form.Set("redirect_uri", cli.RedirectURIs[0])
+		return nil
+	}
+	if !MatchRedirectURI(cli, redirectURI) {
+		return errors.ErrInvalidRedirectURI
+	}
+	return nil
+}
+
+// MatchRedirectURI 回调地址是否在客户端允许的范围内
+// 没有配置 redirect_uris 的客户端要求与 domain 同源
+func MatchRedirectURI(cli *config.OAuth2Client, redirectURI string) bool {
+	redirect, err := url.Parse(redirectURI)
+	if err != nil || redirect.Fragment != "" {
+		return false
+	}
+	if len(cli.RedirectURIs) == 0 {
+		domain, err := url.Parse(cli.Domain)
+		return err == nil && domain.Host != "" &&
+			domain.Scheme == redirect.Scheme && domain.Host == redirect.Host
+	}
+	for _, v := range cli.RedirectURIs {
+		if v == redirectURI {
+			return true
+		}
+		if cli.LoopbackAnyPort && matchLoopback(v, redirect) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLoopback 注册的地址为回环IP时, 忽略端口比较 (RFC 8252 7.3)
+func matchLoopback(registered string, redirect *url.URL) bool {
+	reg, err := url.Parse(registered)
+	if err != nil || reg.Scheme != "http" {
+		return false
+	}
+	ip := net.ParseIP(reg.Hostname())
+	if ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	return redirect.Scheme == reg.Scheme &&
+		redirect.Hostname() == reg.Hostname() &&
+		redirect.Path == reg.Path &&
+		redirect.RawQuery == reg.RawQuery
+}
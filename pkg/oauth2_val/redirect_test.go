@@ -0,0 +1,49 @@
+package oauth2_val_test
+
+import (
+	"oauth2/config"
+	"oauth2/pkg/oauth2_val"
+	"testing"
+)
+
+func TestMatchRedirectURI(t *testing.T) {
+	cli := &config.OAuth2Client{
+		Domain:          "http://localhost:9093",
+		RedirectURIs:    []string{"http://localhost:9093/cb", "http://127.0.0.1/callback"},
+		LoopbackAnyPort: true,
+	}
+	cases := []struct {
+		uri  string
+		want bool
+	}{
+		{"http://localhost:9093/cb", true},
+		{"http://localhost:9093/cb/", false},
+		{"http://localhost:9093/other", false},
+		{"http://localhost:9093/cb?x=1", false},
+		{"http://localhost:9093/cb#frag", false},
+		{"http://evil.localhost:9093/cb", false},
+		{"http://127.0.0.1:51234/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://127.0.0.1:51234/other", false},
+		{"https://127.0.0.1:51234/callback", false},
+	}
+	for _, c := range cases {
+		if got := oauth2_val.MatchRedirectURI(cli, c.uri); got != c.want {
+			t.Errorf("MatchRedirectURI(%q) = %v, want %v", c.uri, got, c.want)
+		}
+	}
+
+	cli.LoopbackAnyPort = false
+	if oauth2_val.MatchRedirectURI(cli, "http://127.0.0.1:51234/callback") {
+		t.Error("loopback port should not be ignored when disabled")
+	}
+
+	// 没有配置 redirect_uris 时要求与 domain 同源
+	legacy := &config.OAuth2Client{Domain: "http://localhost:9093"}
+	if !oauth2_val.MatchRedirectURI(legacy, "http://localhost:9093/any/path") {
+		t.Error("same origin as domain should match")
+	}
+	if oauth2_val.MatchRedirectURI(legacy, "http://localhost:9094/cb") {
+		t.Error("different port should not match")
+	}
+}
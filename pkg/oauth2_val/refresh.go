@@ -0,0 +1,169 @@
+package oauth2_val
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/storage"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/google/uuid"
+)
+
+// token 扩展字段中记录的 refresh token family, 刷新时会带到新的 token 上
+const (
+	extRefreshFamily = "refresh_family"
+	// family 的绝对过期时间(unix秒), 0表示不过期
+	extRefreshFamilyExp = "refresh_family_exp"
+)
+
+// refreshFamilies 与当前 token 存储对应的 family 记录
+var refreshFamilies storage.RefreshFamilyStore
+
+// rotatingTokenStore 保存 token 时维护 refresh token family
+// 第一次签发 refresh token 时创建 family, 之后刷新得到的 refresh token 加入同一个 family,
+// 并按客户端的配置重新计算 refresh token 的有效期
+type rotatingTokenStore struct {
+	oauth2.TokenStore
+	families storage.RefreshFamilyStore
+}
+
+func (s *rotatingTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	eti, ok := info.(oauth2.ExtendableTokenInfo)
+	if !ok || info.GetCode() != "" || info.GetRefresh() == "" {
+		return s.TokenStore.Create(ctx, info)
+	}
+	now := time.Now()
+	cli := config.GetOAuth2Client(info.GetClientID())
+
+	ext := eti.GetExtension()
+	if ext == nil {
+		ext = make(url.Values)
+	}
+	familyID := ext.Get(extRefreshFamily)
+	var familyExp time.Time
+	if familyID == "" {
+		familyID = uuid.NewString()
+		familyExp = refreshAbsoluteExp(cli, info)
+		ext.Set(extRefreshFamily, familyID)
+		ext.Set(extRefreshFamilyExp, strconv.FormatInt(unix(familyExp), 10))
+		eti.SetExtension(ext)
+	} else if v, _ := strconv.ParseInt(ext.Get(extRefreshFamilyExp), 10, 64); v > 0 {
+		familyExp = time.Unix(v, 0)
+	}
+
+	// 滑动有效期从本次刷新开始计算, 不能超过绝对有效期
+	expiresAt := familyExp
+	if cli != nil && cli.RefreshTokenSlidingExp > 0 {
+		sliding := now.Add(time.Hour * time.Duration(cli.RefreshTokenSlidingExp))
+		if expiresAt.IsZero() || sliding.Before(expiresAt) {
+			expiresAt = sliding
+		}
+	}
+	info.SetRefreshCreateAt(now)
+	info.SetRefreshExpiresIn(0)
+	if !expiresAt.IsZero() {
+		d := expiresAt.Sub(now)
+		if d <= 0 {
+			d = time.Millisecond
+		}
+		info.SetRefreshExpiresIn(d)
+	}
+
+	if err := s.TokenStore.Create(ctx, info); err != nil {
+		return err
+	}
+	return s.families.RotateRefresh(ctx, familyID, info.GetRefresh(), expiresAt)
+}
+
+// refreshAbsoluteExp family 的绝对过期时间, 客户端没有配置时使用签发时的默认有效期
+func refreshAbsoluteExp(cli *config.OAuth2Client, info oauth2.TokenInfo) time.Time {
+	if cli != nil && cli.RefreshTokenAbsoluteExp > 0 {
+		return info.GetRefreshCreateAt().Add(time.Hour * time.Duration(cli.RefreshTokenAbsoluteExp))
+	}
+	if exp := info.GetRefreshExpiresIn(); exp > 0 {
+		return info.GetRefreshCreateAt().Add(exp)
+	}
+	return time.Time{}
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// refreshTokenResolveHandler 读取请求中的 refresh token
+// 已经用过的 refresh token 再次出现, 说明它可能已经泄露, 撤销整个 family 的 token
+func refreshTokenResolveHandler(r *http.Request) (string, error) {
+	refresh := r.FormValue("refresh_token")
+	if refresh == "" {
+		return "", errors.ErrInvalidRequest
+	}
+	familyID, used, err := refreshFamilies.GetRefreshFamily(r.Context(), refresh)
+	if err != nil {
+		return "", err
+	}
+	if used {
+		if err := revokeRefreshFamily(r.Context(), familyID, r.RemoteAddr); err != nil {
+			return "", err
+		}
+		return "", errors.ErrInvalidGrant
+	}
+	return refresh, nil
+}
+
+// refreshingValidationHandler 校验通过之后、签发新的 token 之前把 refresh token 标记为已使用
+// 同一个 refresh token 并发刷新时只有一个能标记成功, 其他的按重用处理, 避免 family 分叉
+func refreshingValidationHandler(ti oauth2.TokenInfo) (bool, error) {
+	ctx := context.Background()
+	familyID, _, err := refreshFamilies.GetRefreshFamily(ctx, ti.GetRefresh())
+	if err != nil {
+		return false, err
+	}
+	// 没有 family 记录的是升级之前签发的 refresh token
+	if familyID == "" {
+		return true, nil
+	}
+	ok, err := refreshFamilies.UseRefresh(ctx, ti.GetRefresh())
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if err := revokeRefreshFamily(ctx, familyID, ""); err != nil {
+			return false, err
+		}
+		return false, errors.ErrInvalidGrant
+	}
+	return true, nil
+}
+
+// revokeRefreshFamily 撤销 family 中还有效的 token, 并记录安全事件
+func revokeRefreshFamily(ctx context.Context, familyID, ip string) error {
+	current, err := refreshFamilies.CurrentRefresh(ctx, familyID)
+	if err != nil {
+		return err
+	}
+	var clientID, userID string
+	if current != "" {
+		ti, err := TokenStore.GetByRefresh(ctx, current)
+		if err != nil {
+			return err
+		}
+		if ti != nil {
+			clientID, userID = ti.GetClientID(), ti.GetUserID()
+			if err := removeToken(ctx, ti, "refresh_token"); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("[security] refresh token reuse detected, revoked token family %s (client_id=%s user_id=%s ip=%s)",
+		familyID, clientID, userID, ip)
+	return refreshFamilies.RemoveRefreshFamily(ctx, familyID)
+}
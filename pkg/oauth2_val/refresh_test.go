@@ -0,0 +1,139 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+func setupRefresh(t *testing.T) {
+	setupRegistration(t)
+	cfg := config.GetCfg()
+	old := cfg.OAuth2
+	cfg.OAuth2.JWTSignedKey = "test_key"
+	cfg.OAuth2.AccessTokenExp = 1
+	cfg.OAuth2.TokenStore = "memory"
+	cfg.OAuth2.Client = []config.OAuth2Client{{ID: "app", Secret: "secret", RefreshTokenSlidingExp: 1}}
+	t.Cleanup(func() { cfg.OAuth2 = old })
+	if err := model.SeedClients(context.Background(), cfg.OAuth2.Client); err != nil {
+		t.Fatal(err)
+	}
+	oauth2_val.Setup(context.Background())
+}
+
+// refresh 使用 refresh token 换取新的 token, 失败时返回错误码
+func refresh(t *testing.T, refreshToken string) (string, string) {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("app", "secret")
+	w := httptest.NewRecorder()
+	if err := oauth2_val.Srv.HandleTokenRequest(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	return resp.RefreshToken, resp.Error
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	setupRefresh(t)
+	ctx := context.Background()
+
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(ctx, oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     "app",
+		ClientSecret: "secret",
+		UserID:       "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 滑动有效期比默认的7天短
+	if exp := ti.GetRefreshExpiresIn(); exp <= 0 || exp > time.Hour {
+		t.Error("unexpected refresh token lifetime:", exp)
+	}
+	r0 := ti.GetRefresh()
+
+	r1, errCode := refresh(t, r0)
+	if errCode != "" || r1 == "" || r1 == r0 {
+		t.Fatal("refresh failed:", r1, errCode)
+	}
+	r2, errCode := refresh(t, r1)
+	if errCode != "" || r2 == "" {
+		t.Fatal("refresh failed:", r2, errCode)
+	}
+	current, err := oauth2_val.Mgr.LoadRefreshToken(ctx, r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 再次使用旧的 refresh token, 整个 family 都被撤销
+	if _, errCode := refresh(t, r0); errCode != "invalid_grant" {
+		t.Error("reused refresh token should be rejected:", errCode)
+	}
+	if _, errCode := refresh(t, r2); errCode != "invalid_grant" {
+		t.Error("latest refresh token should be revoked:", errCode)
+	}
+	if _, err := oauth2_val.Mgr.LoadAccessToken(ctx, current.GetAccess()); err == nil {
+		t.Error("access token should be revoked")
+	}
+}
+
+// interleavedGenerate 生成 token 时先执行一次 during, 模拟另一个并发的刷新请求
+type interleavedGenerate struct {
+	oauth2_val.JWTAccessGenerate
+	during func()
+}
+
+func (g *interleavedGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (string, string, error) {
+	if during := g.during; during != nil {
+		g.during = nil
+		during()
+	}
+	return g.JWTAccessGenerate.Token(ctx, data, isGenRefresh)
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	setupRefresh(t)
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(context.Background(), oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     "app",
+		ClientSecret: "secret",
+		UserID:       "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个请求已经读取了 refresh token 还没有保存新的 token 时, 第二个请求使用同一个 refresh token
+	var second, secondErr string
+	gen := &interleavedGenerate{}
+	gen.during = func() { second, secondErr = refresh(t, ti.GetRefresh()) }
+	oauth2_val.Mgr.MapAccessGenerate(gen)
+	first, firstErr := refresh(t, ti.GetRefresh())
+
+	// 只有一个能拿到新的 token, 另一个按重用处理, family 不会分叉
+	if (firstErr == "") == (secondErr == "") {
+		t.Fatalf("exactly one concurrent refresh should succeed: %q %q", firstErr, secondErr)
+	}
+	if firstErr != "invalid_grant" && secondErr != "invalid_grant" {
+		t.Error("the other refresh should be rejected as reuse:", firstErr, secondErr)
+	}
+	if first == "" && second == "" {
+		t.Error("no refresh token issued")
+	}
+}
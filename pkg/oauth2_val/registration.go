@@ -0,0 +1,359 @@
+package oauth2_val
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"oauth2/pkg/model"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// 客户端认证方式
+const (
+	AuthMethodSecretBasic = "client_secret_basic"
+	AuthMethodSecretPost  = "client_secret_post"
+	AuthMethodNone        = "none"
+)
+
+// 动态注册的错误 (RFC 7591 3.2.2)
+var (
+	ErrInvalidRedirectURI    = errors.New("invalid_redirect_uri")
+	ErrInvalidClientMetadata = errors.New("invalid_client_metadata")
+	// ErrInvalidRegistrationToken initial access token 或 registration access token 无效
+	ErrInvalidRegistrationToken = errors.New("invalid_token")
+)
+
+// RegistrationError 带具体描述的注册错误
+type RegistrationError struct {
+	Err         error
+	Description string
+}
+
+func (e *RegistrationError) Error() string {
+	return e.Err.Error() + ": " + e.Description
+}
+
+func metadataError(err error, description string) error {
+	return &RegistrationError{Err: err, Description: description}
+}
+
+// registrableGrantTypes 动态注册的客户端可以使用的授权方式
+// password 需要客户端直接接触用户密码, 只能在配置文件中开启
+var registrableGrantTypes = []string{"authorization_code", "implicit", "refresh_token", "client_credentials", DeviceCodeGrantType}
+
+// registrableAuthMethods 动态注册的客户端可以使用的认证方式
+var registrableAuthMethods = []string{AuthMethodSecretBasic, AuthMethodSecretPost, AuthMethodPrivateKeyJWT, AuthMethodClientSecretJWT, AuthMethodNone}
+
+// ClientMetadata 客户端元数据 (RFC 7591 2)
+type ClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	// private_key_jwt 使用的公钥, 不支持 jwks_uri
+	JWKS *jwk.Set `json:"jwks,omitempty"`
+
+	// 更新时 (RFC 7592 2.2) 需要带上, 必须与当前的一致
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// RegistrationEnabled 是否开放了动态注册
+func RegistrationEnabled() bool {
+	return len(config.GetCfg().OAuth2.Registration.InitialAccessTokens) > 0
+}
+
+// ValidationInitialAccessToken 验证 /register 请求携带的 initial access token
+func ValidationInitialAccessToken(r *http.Request) error {
+	token, ok := bearerToken(r)
+	if !ok {
+		return ErrInvalidRegistrationToken
+	}
+	for _, v := range config.GetCfg().OAuth2.Registration.InitialAccessTokens {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return ErrInvalidRegistrationToken
+}
+
+// AuthenticateRegistration 使用 registration access token 验证并加载客户端 (RFC 7592 3)
+// 客户端不存在和token错误返回同样的错误, 避免探测client_id
+func AuthenticateRegistration(r *http.Request, clientID string) (*model.Client, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrInvalidRegistrationToken
+	}
+	c, err := model.GetClientByID(r.Context(), clientID)
+	if err != nil || c.RegistrationTokenHash == "" {
+		return nil, ErrInvalidRegistrationToken
+	}
+	if subtle.ConstantTimeCompare([]byte(c.RegistrationTokenHash), []byte(hashToken(token))) != 1 {
+		return nil, ErrInvalidRegistrationToken
+	}
+	return c, nil
+}
+
+// RegisterClient 校验元数据并创建客户端
+// 返回的 registration access token 只在这里出现一次, 数据库中只保存哈希
+func RegisterClient(ctx context.Context, md *ClientMetadata) (*model.Client, string, error) {
+	if err := ValidateClientMetadata(md); err != nil {
+		return nil, "", err
+	}
+	c := &model.Client{ID: uuid.NewString()}
+	applyClientMetadata(c, md)
+	if needsSecret(c) {
+		c.Secret = RandomToken()
+	}
+	token := RandomToken()
+	c.RegistrationTokenHash = hashToken(token)
+	if err := model.SaveClient(ctx, c); err != nil {
+		return nil, "", err
+	}
+	return c, token, nil
+}
+
+// UpdateClient 使用新的元数据整体替换客户端的配置 (RFC 7592 2.2)
+func UpdateClient(ctx context.Context, c *model.Client, md *ClientMetadata) error {
+	if md.ClientID != c.ID {
+		return metadataError(ErrInvalidClientMetadata, "client_id does not match")
+	}
+	if md.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(md.ClientSecret), []byte(c.Secret)) != 1 {
+		return metadataError(ErrInvalidClientMetadata, "client_secret does not match")
+	}
+	if err := ValidateClientMetadata(md); err != nil {
+		return err
+	}
+	applyClientMetadata(c, md)
+	switch {
+	case !needsSecret(c):
+		c.Secret = ""
+	case c.Secret == "":
+		c.Secret = RandomToken()
+	}
+	return model.SaveClient(ctx, c)
+}
+
+// needsSecret 公开客户端和使用 private_key_jwt 的客户端不需要 secret
+func needsSecret(c *model.Client) bool {
+	return !c.Public && c.TokenEndpointAuthMethod != AuthMethodPrivateKeyJWT
+}
+
+// ValidateClientMetadata 校验元数据并补全默认值
+func ValidateClientMetadata(md *ClientMetadata) error {
+	if md.TokenEndpointAuthMethod == "" {
+		md.TokenEndpointAuthMethod = AuthMethodSecretBasic
+	}
+	if !contains(registrableAuthMethods, md.TokenEndpointAuthMethod) {
+		return metadataError(ErrInvalidClientMetadata, "unsupported token_endpoint_auth_method "+md.TokenEndpointAuthMethod)
+	}
+
+	if len(md.GrantTypes) == 0 {
+		md.GrantTypes = []string{"authorization_code"}
+	}
+	for _, v := range md.GrantTypes {
+		if !contains(registrableGrantTypes, v) {
+			return metadataError(ErrInvalidClientMetadata, "unsupported grant_type "+v)
+		}
+	}
+	if md.TokenEndpointAuthMethod == AuthMethodNone && contains(md.GrantTypes, "client_credentials") {
+		return metadataError(ErrInvalidClientMetadata, "client_credentials requires client authentication")
+	}
+	if err := validateClientJWTAuth(&config.OAuth2Client{TokenEndpointAuthMethod: md.TokenEndpointAuthMethod, JWKS: jwk.FormatSet(md.JWKS)}); err != nil {
+		return err
+	}
+
+	// response_types 与 grant_types 必须对应 (RFC 7591 2.1)
+	responseTypes := responseTypesFor(md.GrantTypes)
+	for _, v := range md.ResponseTypes {
+		if !contains(responseTypes, v) {
+			return metadataError(ErrInvalidClientMetadata, "response_type "+v+" does not match grant_types")
+		}
+	}
+	md.ResponseTypes = responseTypes
+
+	if len(responseTypes) > 0 && len(md.RedirectURIs) == 0 {
+		return metadataError(ErrInvalidRedirectURI, "redirect_uris is required")
+	}
+	for _, v := range md.RedirectURIs {
+		if err := validateRedirectURI(v); err != nil {
+			return err
+		}
+	}
+	for _, v := range []string{md.ClientURI, md.LogoURI} {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return metadataError(ErrInvalidClientMetadata, "invalid uri "+v)
+		}
+	}
+
+	allowed := config.GetCfg().OAuth2.Registration.Scope
+	if md.Scope == "" {
+		var ids []string
+		for _, s := range allowed {
+			ids = append(ids, s.ID)
+		}
+		md.Scope = strings.Join(ids, " ")
+	}
+	for _, v := range config.SplitScope(md.Scope) {
+		if findScope(allowed, v) == nil {
+			return metadataError(ErrInvalidClientMetadata, "unsupported scope "+v)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI 回调地址必须是绝对地址且不能带fragment
+// http 只允许回环地址; 原生应用的私有scheme需要是反向域名的形式 (RFC 8252 7.1)
+func validateRedirectURI(v string) error {
+	u, err := url.Parse(v)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return metadataError(ErrInvalidRedirectURI, "invalid redirect_uri "+v)
+	}
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return metadataError(ErrInvalidRedirectURI, "invalid redirect_uri "+v)
+		}
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return metadataError(ErrInvalidRedirectURI, "http redirect_uri is only allowed for loopback "+v)
+		}
+	default:
+		if !strings.Contains(u.Scheme, ".") {
+			return metadataError(ErrInvalidRedirectURI, "unsupported redirect_uri scheme "+u.Scheme)
+		}
+	}
+	return nil
+}
+
+// applyClientMetadata 把元数据写入客户端
+func applyClientMetadata(c *model.Client, md *ClientMetadata) {
+	c.Name = md.ClientName
+	c.ClientURI = md.ClientURI
+	c.LogoURI = md.LogoURI
+	c.RedirectURIs = md.RedirectURIs
+	c.GrantTypes = md.GrantTypes
+	c.TokenEndpointAuthMethod = md.TokenEndpointAuthMethod
+	c.Public = md.TokenEndpointAuthMethod == AuthMethodNone
+	c.JWKS = md.JWKS
+
+	c.Scope = make([]config.Scope, 0)
+	for _, v := range config.SplitScope(md.Scope) {
+		c.Scope = append(c.Scope, *findScope(config.GetCfg().OAuth2.Registration.Scope, v))
+	}
+
+	// domain 只用于兼容旧的回调地址校验, 取第一个回调地址的源
+	c.Domain = ""
+	if len(c.RedirectURIs) > 0 {
+		if u, err := url.Parse(c.RedirectURIs[0]); err == nil && u.Host != "" {
+			c.Domain = u.Scheme + "://" + u.Host
+		}
+	}
+}
+
+// ClientRegistrationResponse 注册和读取客户端时返回的内容 (RFC 7591 3.2.1)
+// token 为空时不返回 registration_access_token
+func ClientRegistrationResponse(c *model.Client, token string) map[string]interface{} {
+	var scope []string
+	for _, s := range c.Scope {
+		scope = append(scope, s.ID)
+	}
+	data := map[string]interface{}{
+		"client_id":                  c.ID,
+		"client_id_issued_at":        c.CreatedAt.Unix(),
+		"redirect_uris":              c.RedirectURIs,
+		"token_endpoint_auth_method": c.TokenEndpointAuthMethod,
+		"grant_types":                c.GrantTypes,
+		"response_types":             responseTypesFor(c.GrantTypes),
+		"client_name":                c.Name,
+		"client_uri":                 c.ClientURI,
+		"logo_uri":                   c.LogoURI,
+		"scope":                      strings.Join(scope, " "),
+		"registration_client_uri":    strings.TrimRight(config.GetCfg().OAuth2.Issuer, "/") + "/register/" + c.ID,
+	}
+	if c.JWKS != nil {
+		data["jwks"] = c.JWKS
+	}
+	if c.Secret != "" {
+		data["client_secret"] = c.Secret
+		// 0 表示不过期
+		data["client_secret_expires_at"] = 0
+	}
+	if token != "" {
+		data["registration_access_token"] = token
+	}
+	return data
+}
+
+// responseTypesFor grant_types 对应的 response_types
+func responseTypesFor(grantTypes []string) []string {
+	responseTypes := make([]string, 0)
+	if contains(grantTypes, "authorization_code") {
+		responseTypes = append(responseTypes, "code")
+	}
+	if contains(grantTypes, "implicit") {
+		responseTypes = append(responseTypes, "token")
+	}
+	return responseTypes
+}
+
+// bearerToken 从 Authorization 头中获取 bearer token
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return auth[len(prefix):], true
+}
+
+// RandomToken 生成随机的 secret 或 token
+func RandomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func findScope(scopes []config.Scope, id string) *config.Scope {
+	for i := range scopes {
+		if scopes[i].ID == id {
+			return &scopes[i]
+		}
+	}
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
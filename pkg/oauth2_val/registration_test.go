@@ -0,0 +1,136 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"oauth2/config"
+	"oauth2/pkg/jwk"
+	"oauth2/pkg/model"
+	"oauth2/pkg/oauth2_val"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupRegistration(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(model.Client{}, model.Consent{}); err != nil {
+		t.Fatal(err)
+	}
+	model.GlobalDB = db
+
+	old := config.GetCfg().OAuth2.Registration
+	config.GetCfg().OAuth2.Registration = config.Registration{
+		InitialAccessTokens: []string{"initial"},
+		Scope:               []config.Scope{{ID: "openid", Title: "openid"}, {ID: "email", Title: "email"}},
+	}
+	t.Cleanup(func() {
+		model.GlobalDB = nil
+		config.GetCfg().OAuth2.Registration = old
+	})
+}
+
+// testJWKS 只包含一个 Ed25519 公钥
+var testJWKS = &jwk.Set{Keys: []jwk.JWK{{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}}}
+
+func TestValidateClientMetadata(t *testing.T) {
+	setupRegistration(t)
+	cases := []struct {
+		md   oauth2_val.ClientMetadata
+		want error
+	}{
+		{oauth2_val.ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}}, nil},
+		{oauth2_val.ClientMetadata{RedirectURIs: []string{"http://127.0.0.1:8080/cb"}, TokenEndpointAuthMethod: "none"}, nil},
+		{oauth2_val.ClientMetadata{RedirectURIs: []string{"com.example.app:/cb"}}, nil},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}}, nil},
+		{oauth2_val.ClientMetadata{}, oauth2_val.ErrInvalidRedirectURI},
+		{oauth2_val.ClientMetadata{RedirectURIs: []string{"http://app.example.com/cb"}}, oauth2_val.ErrInvalidRedirectURI},
+		{oauth2_val.ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb#x"}}, oauth2_val.ErrInvalidRedirectURI},
+		{oauth2_val.ClientMetadata{RedirectURIs: []string{"javascript:alert(1)"}}, oauth2_val.ErrInvalidRedirectURI},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"password"}}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "none"}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "tls_client_auth"}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, ResponseTypes: []string{"code"}}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "openid admin"}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "client_secret_jwt"}, nil},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "private_key_jwt", JWKS: testJWKS}, nil},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "private_key_jwt"}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "private_key_jwt", JWKS: &jwk.Set{Keys: []jwk.JWK{{Kty: "oct"}}}}, oauth2_val.ErrInvalidClientMetadata},
+		{oauth2_val.ClientMetadata{GrantTypes: []string{"client_credentials"}, JWKS: testJWKS}, oauth2_val.ErrInvalidClientMetadata},
+	}
+	for i, c := range cases {
+		err := oauth2_val.ValidateClientMetadata(&c.md)
+		var re *oauth2_val.RegistrationError
+		switch {
+		case c.want == nil && err != nil:
+			t.Errorf("case %d: unexpected error %v", i, err)
+		case c.want != nil && (!errors.As(err, &re) || re.Err != c.want):
+			t.Errorf("case %d: got %v, want %v", i, err, c.want)
+		}
+	}
+}
+
+func TestRegisterClient(t *testing.T) {
+	setupRegistration(t)
+	ctx := context.Background()
+
+	c, token, err := oauth2_val.RegisterClient(ctx, &oauth2_val.ClientMetadata{
+		RedirectURIs: []string{"https://app.example.com/cb"},
+		ClientName:   "app",
+		Scope:        "openid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == "" || c.Secret == "" || token == "" || c.IsPublic() || c.Domain != "https://app.example.com" {
+		t.Fatal("unexpected client:", c)
+	}
+	if cli := config.GetOAuth2Client(c.ID); cli != nil {
+		t.Error("default config loader should not see db clients")
+	}
+
+	r := httptest.NewRequest("GET", "/register/"+c.ID, nil)
+	if _, err := oauth2_val.AuthenticateRegistration(r, c.ID); err == nil {
+		t.Error("missing token should fail")
+	}
+	r.Header.Set("Authorization", "Bearer wrong")
+	if _, err := oauth2_val.AuthenticateRegistration(r, c.ID); err == nil {
+		t.Error("wrong token should fail")
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	loaded, err := oauth2_val.AuthenticateRegistration(r, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 改为公开客户端后不再有secret
+	err = oauth2_val.UpdateClient(ctx, loaded, &oauth2_val.ClientMetadata{
+		ClientID:                c.ID,
+		RedirectURIs:            []string{"http://127.0.0.1/cb"},
+		TokenEndpointAuthMethod: "none",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = model.GetClientByID(ctx, c.ID)
+	if !loaded.IsPublic() || loaded.GetSecret() != "" || len(loaded.Scope) != 2 {
+		t.Error("unexpected updated client:", loaded)
+	}
+	data := oauth2_val.ClientRegistrationResponse(loaded, "")
+	if _, ok := data["client_secret"]; ok {
+		t.Error("public client should not have client_secret")
+	}
+	if _, ok := data["registration_access_token"]; ok {
+		t.Error("registration_access_token should only be returned once")
+	}
+
+	if err := oauth2_val.UpdateClient(ctx, loaded, &oauth2_val.ClientMetadata{ClientID: "other"}); err == nil {
+		t.Error("client_id mismatch should fail")
+	}
+}
@@ -0,0 +1,24 @@
+package oauth2_val
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WriteToken 按 Srv 的格式返回 token 端点的 JSON 响应
+func WriteToken(w http.ResponseWriter, data map[string]interface{}, header http.Header, statusCode int) error {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	for key := range header {
+		w.Header().Set(key, header.Get(key))
+	}
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(data)
+}
+
+// WriteTokenError 按 Srv 的格式返回 token 端点的错误
+func WriteTokenError(w http.ResponseWriter, err error) error {
+	data, statusCode, header := Srv.GetErrorData(err)
+	return WriteToken(w, data, header, statusCode)
+}
@@ -0,0 +1,36 @@
+package oauth2_val
+
+import (
+	"context"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// RevokeToken 撤销 token (RFC 7009)
+// 撤销 refresh token 时, 与之关联的 access token 一并撤销;
+// 无效或已经撤销的 token 直接返回成功
+func RevokeToken(ctx context.Context, cli oauth2.ClientInfo, token, hint string) error {
+	ti, tokenType := LoadToken(ctx, token, hint)
+	if ti == nil {
+		return nil
+	}
+	// 只能撤销颁发给自己的 token
+	if ti.GetClientID() != cli.GetID() {
+		return errors.ErrUnauthorizedClient
+	}
+	return removeToken(ctx, ti, tokenType)
+}
+
+// removeToken 从存储中删除 token, 删除 refresh token 时一并删除 access token
+func removeToken(ctx context.Context, ti oauth2.TokenInfo, tokenType string) error {
+	if tokenType == "refresh_token" {
+		if access := ti.GetAccess(); access != "" {
+			if err := Mgr.RemoveAccessToken(ctx, access); err != nil {
+				return err
+			}
+		}
+		return Mgr.RemoveRefreshToken(ctx, ti.GetRefresh())
+	}
+	return Mgr.RemoveAccessToken(ctx, ti.GetAccess())
+}
@@ -0,0 +1,65 @@
+package oauth2_val_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"oauth2/pkg/oauth2_val"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// bearerRequest 模拟资源方调用 /verify
+func bearerRequest(access string) error {
+	r := httptest.NewRequest("GET", "/verify", nil)
+	r.Header.Set("Authorization", "Bearer "+access)
+	_, err := oauth2_val.ValidationBearerToken(r)
+	return err
+}
+
+func TestRevokeToken(t *testing.T) {
+	setupExchange(t)
+	ctx := context.Background()
+	app, _ := oauth2_val.Mgr.GetClient(ctx, "app")
+	other, _ := oauth2_val.Mgr.GetClient(ctx, "other")
+
+	// 撤销 access token 后, 签名有效的 JWT 也不能再通过验证
+	access := issueToken(t, "app", "secret", "1", "profile")
+	if err := bearerRequest(access); err != nil {
+		t.Fatal("token should be valid before revoke:", err)
+	}
+	if err := oauth2_val.RevokeToken(ctx, other, access, ""); err != errors.ErrUnauthorizedClient {
+		t.Error("client should not revoke tokens of other clients:", err)
+	}
+	if err := oauth2_val.RevokeToken(ctx, app, access, "access_token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bearerRequest(access); err == nil {
+		t.Error("revoked JWT should be rejected")
+	}
+	// 已经撤销的 token 再次撤销也返回成功
+	if err := oauth2_val.RevokeToken(ctx, app, access, ""); err != nil {
+		t.Error("revoking a revoked token should succeed:", err)
+	}
+
+	// 撤销 refresh token 时一并撤销 access token
+	ti, err := oauth2_val.Mgr.GenerateAccessToken(ctx, oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+		ClientID:     "app",
+		ClientSecret: "secret",
+		UserID:       "1",
+		Scope:        "profile",
+	})
+	if err != nil || ti.GetRefresh() == "" {
+		t.Fatal("missing refresh token:", err)
+	}
+	if err := oauth2_val.RevokeToken(ctx, app, ti.GetRefresh(), "refresh_token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oauth2_val.Mgr.LoadRefreshToken(ctx, ti.GetRefresh()); err == nil {
+		t.Error("refresh token should be revoked")
+	}
+	if err := bearerRequest(ti.GetAccess()); err == nil {
+		t.Error("access token should be revoked together with its refresh token")
+	}
+}
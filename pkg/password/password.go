@@ -0,0 +1,170 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"oauth2/config"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 支持的哈希算法
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// 默认参数
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	argon2SaltLen            = 16
+	argon2KeyLen             = 32
+)
+
+// ErrUnsupportedAlgorithm 不支持的哈希算法
+var ErrUnsupportedAlgorithm = errors.New("unsupported password algorithm")
+
+// Hasher 按配置生成和验证密码哈希
+type Hasher struct {
+	algorithm   string
+	bcryptCost  int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// New 创建Hasher, 未配置的参数使用默认值
+func New(cfg config.Password) *Hasher {
+	h := &Hasher{
+		algorithm:   cfg.Algorithm,
+		bcryptCost:  cfg.BcryptCost,
+		memory:      cfg.Argon2.Memory,
+		iterations:  cfg.Argon2.Iterations,
+		parallelism: cfg.Argon2.Parallelism,
+	}
+	if h.algorithm == "" {
+		h.algorithm = Bcrypt
+	}
+	if h.bcryptCost == 0 {
+		h.bcryptCost = bcrypt.DefaultCost
+	}
+	if h.memory == 0 {
+		h.memory = defaultArgon2Memory
+	}
+	if h.iterations == 0 {
+		h.iterations = defaultArgon2Iterations
+	}
+	if h.parallelism == 0 {
+		h.parallelism = defaultArgon2Parallelism
+	}
+	return h
+}
+
+// Hash 生成密码哈希
+// bcrypt 为标准的 $2a$ 格式, argon2id 为 PHC 格式: $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+func (h *Hasher) Hash(plain string) (string, error) {
+	switch h.algorithm {
+	case Bcrypt:
+		b, err := bcrypt.GenerateFromPassword([]byte(plain), h.bcryptCost)
+		return string(b), err
+	case Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(plain), salt, h.iterations, h.memory, h.parallelism, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, h.memory, h.iterations, h.parallelism,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", ErrUnsupportedAlgorithm
+}
+
+// IsHashed 存储的值是否已经是哈希
+func IsHashed(stored string) bool {
+	return isBcrypt(stored) || strings.HasPrefix(stored, "$argon2id$")
+}
+
+func isBcrypt(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// Verify 验证密码
+// needsRehash 表示验证通过但存储的是明文或者与当前配置不一致的哈希, 应当重新哈希
+// 空的存储值永远不能通过验证
+func (h *Hasher) Verify(stored, plain string) (ok bool, needsRehash bool) {
+	if stored == "" {
+		return false, false
+	}
+
+	switch {
+	case isBcrypt(stored):
+		if bcrypt.CompareHashAndPassword([]byte(stored), []byte(plain)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(stored))
+		return true, h.algorithm != Bcrypt || err != nil || cost != h.bcryptCost
+	case strings.HasPrefix(stored, "$argon2id$"):
+		p, err := parseArgon2(stored)
+		if err != nil {
+			return false, false
+		}
+		key := argon2.IDKey([]byte(plain), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+		if subtle.ConstantTimeCompare(key, p.key) != 1 {
+			return false, false
+		}
+		return true, h.algorithm != Argon2id || p.memory != h.memory ||
+			p.iterations != h.iterations || p.parallelism != h.parallelism
+	}
+
+	// 旧数据中的明文密码
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(plain)) != 1 {
+		return false, false
+	}
+	return true, true
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func parseArgon2(stored string) (*argon2Params, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, errors.New("incompatible argon2 version")
+	}
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(p.salt) == 0 || len(p.key) == 0 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	return p, nil
+}
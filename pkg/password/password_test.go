@@ -0,0 +1,75 @@
+package password_test
+
+import (
+	"oauth2/config"
+	"oauth2/pkg/password"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{password.Bcrypt, password.Argon2id} {
+		cfg := config.Password{Algorithm: algorithm, BcryptCost: bcrypt.MinCost}
+		cfg.Argon2.Memory = 1024
+		cfg.Argon2.Iterations = 1
+		h := password.New(cfg)
+
+		hash, err := h.Hash("secret")
+		if err != nil {
+			t.Fatal(algorithm, err)
+		}
+		if !password.IsHashed(hash) || strings.Contains(hash, "secret") {
+			t.Error(algorithm, "unexpected hash:", hash)
+		}
+		if ok, rehash := h.Verify(hash, "secret"); !ok || rehash {
+			t.Error(algorithm, "verify:", ok, rehash)
+		}
+		if ok, _ := h.Verify(hash, "wrong"); ok {
+			t.Error(algorithm, "wrong password should not match")
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	old := password.New(config.Password{Algorithm: password.Bcrypt, BcryptCost: bcrypt.MinCost})
+	hash, _ := old.Hash("secret")
+
+	// cost 变化
+	h := password.New(config.Password{Algorithm: password.Bcrypt, BcryptCost: bcrypt.MinCost + 1})
+	if ok, rehash := h.Verify(hash, "secret"); !ok || !rehash {
+		t.Error("cost change should need rehash:", ok, rehash)
+	}
+
+	// 算法变化
+	cfg := config.Password{Algorithm: password.Argon2id}
+	cfg.Argon2.Memory = 1024
+	cfg.Argon2.Iterations = 1
+	h = password.New(cfg)
+	if ok, rehash := h.Verify(hash, "secret"); !ok || !rehash {
+		t.Error("algorithm change should need rehash:", ok, rehash)
+	}
+}
+
+func TestVerifyPlaintext(t *testing.T) {
+	h := password.New(config.Password{})
+	if ok, rehash := h.Verify("secret", "secret"); !ok || !rehash {
+		t.Error("plaintext should match and need rehash:", ok, rehash)
+	}
+	if ok, _ := h.Verify("secret", "wrong"); ok {
+		t.Error("wrong plaintext should not match")
+	}
+	if ok, _ := h.Verify("", ""); ok {
+		t.Error("empty stored value should never match")
+	}
+	if ok, _ := h.Verify("$argon2id$v=19$m=1024,t=1,p=1$$", ""); ok {
+		t.Error("malformed hash should never match")
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	if _, err := password.New(config.Password{Algorithm: "md5"}).Hash("secret"); err != password.ErrUnsupportedAlgorithm {
+		t.Error("expected ErrUnsupportedAlgorithm, got", err)
+	}
+}
@@ -9,8 +9,44 @@ func Setup(r *gin.Engine) {
 	r.GET("/authorize", controller.AuthorizeHandler)
 	r.POST("/login", controller.LoginHandler)
 	r.GET("/logout", controller.LogoutHandler)
+	r.GET("/consent", controller.ConsentHandler)
+	r.POST("/consent", controller.ConsentSubmitHandler)
+	r.GET("/sessions", controller.SessionsHandler)
+	r.POST("/sessions/terminate", controller.TerminateSessionHandler)
 	r.POST("/token", controller.TokenHandler)
+	r.POST("/device_authorization", controller.DeviceAuthorizationHandler)
+	r.GET("/device", controller.DeviceHandler)
+	r.POST("/device", controller.DeviceSubmitHandler)
 	r.GET("/verify", controller.VerifyHandler)
+	r.POST("/introspect", controller.IntrospectHandler)
+	r.POST("/revoke", controller.RevokeHandler)
+	r.POST("/register", controller.RegisterHandler)
+	r.GET("/register/:client_id", controller.GetRegistrationHandler)
+	r.PUT("/register/:client_id", controller.UpdateRegistrationHandler)
+	r.DELETE("/register/:client_id", controller.DeleteRegistrationHandler)
+	r.GET("/userinfo", controller.UserInfoHandler)
+	r.POST("/userinfo", controller.UserInfoHandler)
+	r.GET("/.well-known/openid-configuration", controller.DiscoveryHandler)
+	r.GET("/.well-known/jwks.json", controller.JWKSHandler)
+
+	// 管理接口, access token 需要包含 admin scope
+	admin := r.Group("/admin/api", controller.AdminAuth)
+	admin.GET("/users", controller.ListUsersHandler)
+	admin.POST("/users", controller.CreateUserHandler)
+	admin.GET("/users/:id", controller.GetUserHandler)
+	admin.PUT("/users/:id", controller.UpdateUserHandler)
+	admin.DELETE("/users/:id", controller.DeleteUserHandler)
+	admin.GET("/users/:id/sessions", controller.ListUserSessionsHandler)
+	admin.DELETE("/users/:id/sessions", controller.DeleteUserSessionsHandler)
+	admin.GET("/clients", controller.ListClientsHandler)
+	admin.POST("/clients", controller.CreateClientHandler)
+	admin.GET("/clients/:id", controller.GetClientHandler)
+	admin.PUT("/clients/:id", controller.UpdateClientHandler)
+	admin.DELETE("/clients/:id", controller.DeleteClientHandler)
+	admin.GET("/tokens", controller.ListTokensHandler)
+	admin.DELETE("/tokens", controller.RevokeTokensHandler)
+	admin.POST("/tokens/revoke", controller.RevokeTokenHandler)
+
 	// 静态文件服务，使用项目根目录下的 static 目录
 	r.Static("/static", controller.GetTemplatePath("static"))
 	r.GET("/login", controller.GETloginHandler)
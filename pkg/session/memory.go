@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryBackend 保存在进程内存中, 只适用于单实例部署, 重启后session丢失
+type MemoryBackend struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{sessions: make(map[string]memoryEntry)}
+}
+
+func (b *MemoryBackend) Load(ctx context.Context, id string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.sessions[id]
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, nil
+	}
+	return e.data, nil
+}
+
+func (b *MemoryBackend) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sessions[id] = memoryEntry{data: data, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, id)
+	return nil
+}
+
+// Cleanup 删除过期的session
+func (b *MemoryBackend) Cleanup(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for id, e := range b.sessions {
+		if now.After(e.expiresAt) {
+			delete(b.sessions, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackend 保存在redis中, 使用redis的过期时间, 不需要定时清理
+//
+//	{prefix}session:{id} -> session数据
+type RedisBackend struct {
+	cli    redis.UniversalClient
+	prefix string
+}
+
+func NewRedisBackend(cli redis.UniversalClient, keyPrefix string) *RedisBackend {
+	return &RedisBackend{cli: cli, prefix: keyPrefix}
+}
+
+func (b *RedisBackend) key(id string) string {
+	return b.prefix + "session:" + id
+}
+
+func (b *RedisBackend) Load(ctx context.Context, id string) ([]byte, error) {
+	data, err := b.cli.Get(ctx, b.key(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b *RedisBackend) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return b.cli.Set(ctx, b.key(id), data, ttl).Err()
+}
+
+func (b *RedisBackend) Delete(ctx context.Context, id string) error {
+	return b.cli.Del(ctx, b.key(id)).Err()
+}
@@ -1,24 +1,87 @@
 package session
 
 import (
+	"context"
 	"encoding/gob"
 	"github.com/gorilla/sessions"
+	"log"
 	"net/http"
 	"net/url"
 	"oauth2/config"
+	"oauth2/pkg/model"
+	"time"
 )
 
-var store *sessions.CookieStore
+var store sessions.Store
 
-func Setup() {
+// cleaner 需要定时清理过期数据的存储
+type cleaner interface {
+	Cleanup(ctx context.Context) error
+}
+
+type cleanerFunc func(ctx context.Context) error
+
+func (f cleanerFunc) Cleanup(ctx context.Context) error {
+	return f(ctx)
+}
+
+// session中保存的非基础类型需要注册
+func init() {
 	gob.Register(url.Values{})
+}
 
-	store = sessions.NewCookieStore([]byte(config.GetCfg().Session.SecretKey))
-	store.Options = &sessions.Options{
+func Setup(ctx context.Context) {
+	cfg := config.GetCfg().Session
+	options := &sessions.Options{
 		Path:     "/",
-		MaxAge:   config.GetCfg().Session.MaxAge,
+		MaxAge:   cfg.MaxAge,
 		HttpOnly: true,
 	}
+
+	// 登录会话保存在数据库中, 与session的存储方式无关
+	go cleanup(ctx, cleanerFunc(model.CleanupSSOSessions), time.Minute)
+
+	var backend Backend
+	switch cfg.Store {
+	case "memory":
+		backend = NewMemoryBackend()
+	case "redis":
+		backend = NewRedisBackend(model.Redis(), config.GetCfg().Redis.Default.KeyPrefix)
+	case "db":
+		b, err := NewSQLBackend(model.GlobalDB)
+		if err != nil {
+			panic(err)
+		}
+		backend = b
+	default:
+		// 全部数据保存在cookie中
+		cookieStore := sessions.NewCookieStore([]byte(cfg.SecretKey))
+		cookieStore.Options = options
+		store = cookieStore
+		return
+	}
+
+	serverStore := NewServerStore(backend, []byte(cfg.SecretKey))
+	serverStore.Options = options
+	store = serverStore
+	if c, ok := backend.(cleaner); ok {
+		go cleanup(ctx, c, time.Minute)
+	}
+}
+
+func cleanup(ctx context.Context, c cleaner, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Cleanup(ctx); err != nil {
+				log.Println("Session cleanup error:", err)
+			}
+		}
+	}
 }
 
 // Get 获得session中key对应的值
@@ -51,3 +114,32 @@ func Delete(w http.ResponseWriter, r *http.Request, name string) (err error) {
 	err = sessions.Save(r, w)
 	return
 }
+
+// Regenerate 更换 session id, 登录时在写入用户信息之前调用
+// cookie 存储没有服务端的 id, 不需要处理
+func Regenerate(w http.ResponseWriter, r *http.Request) (err error) {
+	session, err := store.Get(r, config.GetCfg().Session.Name)
+	if err != nil {
+		return
+	}
+	if s, ok := store.(*ServerStore); ok {
+		if err = s.Regenerate(r, session); err != nil {
+			return
+		}
+	}
+	err = sessions.Save(r, w)
+	return
+}
+
+// Destroy 删除整个session
+// 使用服务端存储时会同时删除服务端的数据, 之前的cookie即使被保存下来也不能再使用
+func Destroy(w http.ResponseWriter, r *http.Request) (err error) {
+	session, err := store.Get(r, config.GetCfg().Session.Name)
+	if err != nil {
+		return
+	}
+	session.Values = make(map[interface{}]interface{})
+	session.Options.MaxAge = -1
+	err = sessions.Save(r, w)
+	return
+}
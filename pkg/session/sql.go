@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sqlSession session表的结构
+type sqlSession struct {
+	ID        string    `gorm:"primaryKey;size:64"`
+	Data      []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (sqlSession) TableName() string {
+	return "oauth2_session"
+}
+
+// SQLBackend 保存在数据库的 oauth2_session 表中, 过期数据需要定时调用 Cleanup 清理
+type SQLBackend struct {
+	db *gorm.DB
+}
+
+// NewSQLBackend 创建数据库session存储, 会自动创建表
+func NewSQLBackend(db *gorm.DB) (*SQLBackend, error) {
+	if err := db.AutoMigrate(&sqlSession{}); err != nil {
+		return nil, err
+	}
+	return &SQLBackend{db: db}, nil
+}
+
+func (b *SQLBackend) Load(ctx context.Context, id string) ([]byte, error) {
+	var s sqlSession
+	// 时间统一使用UTC, sqlite 按字符串比较时间
+	err := b.db.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now().UTC()).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Data, nil
+}
+
+func (b *SQLBackend) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return b.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&sqlSession{
+		ID:        id,
+		Data:      data,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}).Error
+}
+
+func (b *SQLBackend) Delete(ctx context.Context, id string) error {
+	return b.db.WithContext(ctx).Where("id = ?", id).Delete(&sqlSession{}).Error
+}
+
+// Cleanup 删除过期的session
+func (b *SQLBackend) Cleanup(ctx context.Context) error {
+	return b.db.WithContext(ctx).Where("expires_at <= ?", time.Now().UTC()).Delete(&sqlSession{}).Error
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"oauth2/config"
+	"oauth2/pkg/model"
+	"time"
+)
+
+// touchInterval 最后活动时间的更新间隔, 避免每个请求都写数据库
+const touchInterval = time.Minute
+
+// ssoTTL 登录会话在没有活动之后的有效期, 与session的 max_age 一致
+func ssoTTL() time.Duration {
+	if maxAge := config.GetCfg().Session.MaxAge; maxAge > 0 {
+		return time.Duration(maxAge) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+// StartSSO 登录成功后创建登录会话, 并把id记录在session中
+// 同一个浏览器之前的登录会话会被删除, 需要在修改 LoggedInUserID 之前调用
+func StartSSO(w http.ResponseWriter, r *http.Request, userID, ip string) error {
+	if old, err := CurrentSSO(r); err != nil {
+		return err
+	} else if old != nil {
+		if _, err := model.DeleteSSOSession(r.Context(), old.UserID, old.ID); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	s := &model.SSOSession{
+		ID:           newID(),
+		UserID:       userID,
+		IP:           ip,
+		UserAgent:    userAgent,
+		Clients:      []string{},
+		CreatedAt:    now,
+		LastActiveAt: now,
+		ExpiresAt:    now.Add(ssoTTL()),
+	}
+	if err := model.SaveSSOSession(r.Context(), s); err != nil {
+		return err
+	}
+	return Set(w, r, "SSOSessionID", s.ID)
+}
+
+// CurrentSSO 当前浏览器的登录会话
+// 没有登录, 会话已被终止或过期, 或者与session中的用户不一致时返回nil
+func CurrentSSO(r *http.Request) (*model.SSOSession, error) {
+	id, _ := Get(r, "SSOSessionID")
+	userID, _ := Get(r, "LoggedInUserID")
+	if id == nil || userID == nil {
+		return nil, nil
+	}
+	s, err := model.GetSSOSession(r.Context(), id.(string))
+	if err != nil || s == nil || s.UserID != userID.(string) {
+		return nil, err
+	}
+	return s, nil
+}
+
+// TouchSSO 更新最后活动时间, 并记录本次授权的客户端
+func TouchSSO(ctx context.Context, s *model.SSOSession, clientID string) error {
+	now := time.Now().UTC()
+	changed := now.Sub(s.LastActiveAt) > touchInterval
+	if clientID != "" && !contains(s.Clients, clientID) {
+		s.Clients = append(s.Clients, clientID)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	s.LastActiveAt = now
+	s.ExpiresAt = now.Add(ssoTTL())
+	return model.SaveSSOSession(ctx, s)
+}
+
+// EndSSO 删除当前浏览器的登录会话和整个session
+func EndSSO(w http.ResponseWriter, r *http.Request) error {
+	if s, err := CurrentSSO(r); err != nil {
+		log.Println("Load sso session error:", err)
+	} else if s != nil {
+		if _, err := model.DeleteSSOSession(r.Context(), s.UserID, s.ID); err != nil {
+			return err
+		}
+	}
+	return Destroy(w, r)
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/gob"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Backend 服务端session数据的存储
+type Backend interface {
+	// Load 读取session数据, 不存在或已过期时返回nil
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Save 保存session数据, ttl 后过期
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete 删除session
+	Delete(ctx context.Context, id string) error
+}
+
+// ServerStore 把session数据保存在服务端, cookie中只有签名后的session id
+// 实现了 sessions.Store
+type ServerStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	backend Backend
+}
+
+// NewServerStore 创建服务端session存储
+// keyPairs 用于签名cookie中的session id, 同 sessions.NewCookieStore
+func NewServerStore(backend Backend, keyPairs ...[]byte) *ServerStore {
+	return &ServerStore{
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{Path: "/", MaxAge: 86400 * 30},
+		backend: backend,
+	}
+}
+
+// Get 同一个请求中多次获取时返回同一个session
+func (s *ServerStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New 根据cookie中的id加载session
+// cookie无效或session已过期时返回新的session, 保存时会生成新的id
+func (s *ServerStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.Codecs...); err != nil {
+		return session, nil
+	}
+	data, err := s.backend.Load(r.Context(), id)
+	if err != nil {
+		return session, err
+	}
+	if data == nil {
+		return session, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save 保存session数据并写入cookie
+// MaxAge < 0 时删除服务端数据和cookie
+func (s *ServerStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(r.Context(), session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = newID()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return err
+	}
+	if err := s.backend.Save(r.Context(), session.ID, buf.Bytes(), s.ttl(session)); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Regenerate 删除服务端的旧数据并清空 id, 保存时使用新的 id, session 中的数据保留
+// 登录时调用, 登录之前被植入的 cookie 拿不到登录状态(会话固定)
+func (s *ServerStore) Regenerate(r *http.Request, session *sessions.Session) error {
+	if session.ID != "" {
+		if err := s.backend.Delete(r.Context(), session.ID); err != nil {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// ttl 服务端数据的有效期, MaxAge 为0(浏览器关闭时失效)时保留一天
+func (s *ServerStore) ttl(session *sessions.Session) time.Duration {
+	if session.Options.MaxAge > 0 {
+		return time.Duration(session.Options.MaxAge) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+func newID() string {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+}
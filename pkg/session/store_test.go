@@ -0,0 +1,157 @@
+package session_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2/pkg/session"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+func backends(t *testing.T) map[string]session.Backend {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlBackend, err := session.NewSQLBackend(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	return map[string]session.Backend{
+		"memory": session.NewMemoryBackend(),
+		"redis":  session.NewRedisBackend(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test:"),
+		"db":     sqlBackend,
+	}
+}
+
+// roundTrip 保存session并返回写入的cookie
+func roundTrip(t *testing.T, store *session.ServerStore, r *http.Request, fn func(values map[interface{}]interface{})) (*http.Cookie, map[interface{}]interface{}) {
+	s, err := store.New(r, "sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(s.Values)
+	w := httptest.NewRecorder()
+	if err := store.Save(r, w, s); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected one cookie, got %d", len(cookies))
+	}
+	return cookies[0], s.Values
+}
+
+func TestServerStore(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := session.NewServerStore(backend, []byte("secret"))
+			form := url.Values{"client_id": {"app_1"}, "scope": {strings.Repeat("openid ", 1000)}}
+
+			cookie, _ := roundTrip(t, store, httptest.NewRequest("GET", "/", nil), func(v map[interface{}]interface{}) {
+				v["RequestForm"] = form
+				v["LoggedInUserID"] = "1"
+			})
+			// cookie中只有签名后的id
+			if len(cookie.Value) > 200 || strings.Contains(cookie.Value, "openid") {
+				t.Errorf("cookie should only contain the session id, got %d bytes", len(cookie.Value))
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(cookie)
+			s, err := store.New(r, "sid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.IsNew || s.Values["LoggedInUserID"] != "1" || s.Values["RequestForm"].(url.Values).Get("client_id") != "app_1" {
+				t.Errorf("session not loaded: %v", s.Values)
+			}
+
+			// 删除之后同一个cookie不能再使用
+			s.Options.MaxAge = -1
+			if err := store.Save(r, httptest.NewRecorder(), s); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := backend.Load(context.Background(), s.ID); data != nil {
+				t.Error("session should be deleted from backend")
+			}
+			r = httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(cookie)
+			if s, _ := store.New(r, "sid"); !s.IsNew || len(s.Values) != 0 {
+				t.Error("destroyed session should not be loaded")
+			}
+		})
+	}
+}
+
+func TestServerStoreInvalidCookie(t *testing.T) {
+	store := session.NewServerStore(session.NewMemoryBackend(), []byte("secret"))
+	other := session.NewServerStore(session.NewMemoryBackend(), []byte("other"))
+	cookie, _ := roundTrip(t, other, httptest.NewRequest("GET", "/", nil), func(v map[interface{}]interface{}) {
+		v["LoggedInUserID"] = "1"
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	s, err := store.New(r, "sid")
+	if err != nil || !s.IsNew || len(s.Values) != 0 {
+		t.Errorf("cookie signed with another key should start a new session: %v %v", s.Values, err)
+	}
+}
+
+func TestServerStoreRegenerate(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := session.NewServerStore(backend, []byte("secret"))
+			// 攻击者事先拿到的 cookie
+			planted, _ := roundTrip(t, store, httptest.NewRequest("GET", "/", nil), func(v map[interface{}]interface{}) {
+				v["RequestForm"] = url.Values{"client_id": {"app_1"}}
+			})
+
+			// 用户带着这个 cookie 登录
+			r := httptest.NewRequest("POST", "/login", nil)
+			r.AddCookie(planted)
+			s, err := store.New(r, "sid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldID := s.ID
+			if err := store.Regenerate(r, s); err != nil {
+				t.Fatal(err)
+			}
+			s.Values["LoggedInUserID"] = "1"
+			w := httptest.NewRecorder()
+			if err := store.Save(r, w, s); err != nil {
+				t.Fatal(err)
+			}
+			if s.ID == "" || s.ID == oldID {
+				t.Fatal("session id should change")
+			}
+			if data, _ := backend.Load(context.Background(), oldID); data != nil {
+				t.Error("old session should be deleted from backend")
+			}
+
+			// 旧 cookie 拿不到登录状态, 新 cookie 保留了登录前的数据
+			r = httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(planted)
+			if s, _ := store.New(r, "sid"); !s.IsNew || s.Values["LoggedInUserID"] != nil {
+				t.Error("planted cookie should not carry the login")
+			}
+			r = httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(w.Result().Cookies()[0])
+			s, _ = store.New(r, "sid")
+			if s.Values["LoggedInUserID"] != "1" || s.Values["RequestForm"].(url.Values).Get("client_id") != "app_1" {
+				t.Errorf("regenerated session not loaded: %v", s.Values)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"oauth2/pkg/model"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"gorm.io/gorm"
+)
+
+// ClientStore 基于数据库 oauth2_client 表的客户端存储
+type ClientStore struct{}
+
+// NewClientStore 创建客户端存储实例
+func NewClientStore() *ClientStore {
+	return &ClientStore{}
+}
+
+// GetByID 根据clientID获取客户端, 不存在时返回nil, 由Manager转换为invalid_client
+func (s *ClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	c, err := model.GetClientByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
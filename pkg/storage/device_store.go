@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 设备授权请求的状态
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+)
+
+// DeviceRetention 设备授权请求过期之后继续保留的时间
+// 期间设备轮询会收到 expired_token, 而不是 invalid_grant
+const DeviceRetention = time.Hour
+
+// DeviceAuthorization 等待用户确认的设备授权请求 (RFC 8628)
+type DeviceAuthorization struct {
+	DeviceCode string `json:"device_code"`
+	UserCode   string `json:"user_code"`
+	ClientID   string `json:"client_id"`
+	Scope      string `json:"scope"`
+	Status     string `json:"status"`
+	// 用户确认后记录, Scope 也会更新为用户同意的部分
+	UserID string `json:"user_id"`
+	// 轮询间隔, 单位秒, 设备轮询过快时会增加
+	Interval     int       `json:"interval"`
+	LastPolledAt time.Time `json:"last_polled_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// DeviceStore 保存设备授权请求, 过期之后保留 DeviceRetention 再删除
+type DeviceStore interface {
+	// CreateDevice 创建设备授权请求, user_code 重复时返回错误
+	CreateDevice(ctx context.Context, d *DeviceAuthorization) error
+	// UpdateDevice 更新设备授权请求的状态和轮询信息
+	// 只有保存的状态仍为 status 时才更新, 返回是否更新了记录;
+	// 用户确认和设备轮询同时发生时, 后写入的一方不会覆盖前者的结果
+	UpdateDevice(ctx context.Context, d *DeviceAuthorization, status string) (bool, error)
+	// GetDeviceByDeviceCode 不存在时返回nil
+	GetDeviceByDeviceCode(ctx context.Context, deviceCode string) (*DeviceAuthorization, error)
+	// GetDeviceByUserCode 不存在时返回nil
+	GetDeviceByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	// RemoveDevice 删除设备授权请求, 返回是否删除了记录
+	// 签发 token 之前调用, 并发的轮询只有一个能签发
+	RemoveDevice(ctx context.Context, deviceCode string) (bool, error)
+}
+
+// ErrDuplicateUserCode user_code 已经存在
+var ErrDuplicateUserCode = errors.New("duplicate user_code")
+
+// MemoryDeviceStore 保存在进程内存中的 DeviceStore, 与内存 token 存储一起使用
+type MemoryDeviceStore struct {
+	mu      sync.Mutex
+	devices map[string]DeviceAuthorization
+	// user_code -> device_code
+	userCodes map[string]string
+	lastSweep time.Time
+}
+
+// NewMemoryDeviceStore 创建内存 DeviceStore
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{
+		devices:   make(map[string]DeviceAuthorization),
+		userCodes: make(map[string]string),
+	}
+}
+
+func deviceExpired(d DeviceAuthorization, t time.Time) bool {
+	return !d.ExpiresAt.Add(DeviceRetention).After(t)
+}
+
+// CreateDevice 实现 DeviceStore
+func (s *MemoryDeviceStore) CreateDevice(ctx context.Context, d *DeviceAuthorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	if _, ok := s.userCodes[d.UserCode]; ok {
+		return ErrDuplicateUserCode
+	}
+	s.devices[d.DeviceCode] = *d
+	s.userCodes[d.UserCode] = d.DeviceCode
+	return nil
+}
+
+// UpdateDevice 实现 DeviceStore
+func (s *MemoryDeviceStore) UpdateDevice(ctx context.Context, d *DeviceAuthorization, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.devices[d.DeviceCode]; !ok || old.Status != status {
+		return false, nil
+	}
+	s.devices[d.DeviceCode] = *d
+	return true, nil
+}
+
+// GetDeviceByDeviceCode 实现 DeviceStore
+func (s *MemoryDeviceStore) GetDeviceByDeviceCode(ctx context.Context, deviceCode string) (*DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[deviceCode]
+	if !ok || deviceExpired(d, time.Now()) {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// GetDeviceByUserCode 实现 DeviceStore
+func (s *MemoryDeviceStore) GetDeviceByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	s.mu.Lock()
+	deviceCode, ok := s.userCodes[userCode]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return s.GetDeviceByDeviceCode(ctx, deviceCode)
+}
+
+// RemoveDevice 实现 DeviceStore
+func (s *MemoryDeviceStore) RemoveDevice(ctx context.Context, deviceCode string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[deviceCode]
+	if !ok {
+		return false, nil
+	}
+	delete(s.devices, deviceCode)
+	delete(s.userCodes, d.UserCode)
+	return true, nil
+}
+
+// sweep 每分钟最多清理一次过期的请求, 调用方需要持有锁
+func (s *MemoryDeviceStore) sweep() {
+	t := time.Now()
+	if t.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = t
+	for code, d := range s.devices {
+		if deviceExpired(d, t) {
+			delete(s.devices, code)
+			delete(s.userCodes, d.UserCode)
+		}
+	}
+}
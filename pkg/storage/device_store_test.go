@@ -0,0 +1,99 @@
+package storage_test
+
+import (
+	"context"
+	"oauth2/pkg/storage"
+	"testing"
+	"time"
+)
+
+func testDeviceStore(t *testing.T, s storage.DeviceStore) {
+	ctx := context.Background()
+	d := &storage.DeviceAuthorization{
+		DeviceCode: "device_1",
+		UserCode:   "BCDFGHJK",
+		ClientID:   "app",
+		Scope:      "profile",
+		Status:     storage.DeviceStatusPending,
+		Interval:   5,
+		ExpiresAt:  time.Now().Add(time.Minute),
+	}
+	if err := s.CreateDevice(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	dup := *d
+	dup.DeviceCode = "device_2"
+	if err := s.CreateDevice(ctx, &dup); err != storage.ErrDuplicateUserCode {
+		t.Error("duplicate user_code should be rejected:", err)
+	}
+
+	got, err := s.GetDeviceByUserCode(ctx, "BCDFGHJK")
+	if err != nil || got == nil || got.DeviceCode != "device_1" {
+		t.Fatal("device not found by user_code:", got, err)
+	}
+	// 轮询先读取了请求, 用户确认之后才写入
+	poll, err := s.GetDeviceByDeviceCode(ctx, "device_1")
+	if err != nil || poll == nil {
+		t.Fatal("device not found by device_code:", poll, err)
+	}
+	got.Status = storage.DeviceStatusApproved
+	got.UserID = "1"
+	if ok, err := s.UpdateDevice(ctx, got, storage.DeviceStatusPending); err != nil || !ok {
+		t.Fatal("device not updated:", ok, err)
+	}
+	poll.Interval += 5
+	poll.LastPolledAt = time.Now()
+	if ok, err := s.UpdateDevice(ctx, poll, storage.DeviceStatusPending); err != nil || ok {
+		t.Error("poll should not overwrite the approval:", ok, err)
+	}
+	got, err = s.GetDeviceByDeviceCode(ctx, "device_1")
+	if err != nil || got == nil || got.Status != storage.DeviceStatusApproved || got.UserID != "1" {
+		t.Fatal("device not updated:", got, err)
+	}
+	if ok, err := s.UpdateDevice(ctx, &dup, storage.DeviceStatusPending); err != nil || ok {
+		t.Error("unknown device should not be updated:", ok, err)
+	}
+	if got, err := s.GetDeviceByDeviceCode(ctx, "unknown"); err != nil || got != nil {
+		t.Error("unknown device_code:", got, err)
+	}
+
+	// 只能删除一次
+	if ok, err := s.RemoveDevice(ctx, "device_1"); err != nil || !ok {
+		t.Error("remove device failed:", ok, err)
+	}
+	if ok, err := s.RemoveDevice(ctx, "device_1"); err != nil || ok {
+		t.Error("device removed twice:", ok, err)
+	}
+	if got, err := s.GetDeviceByUserCode(ctx, "BCDFGHJK"); err != nil || got != nil {
+		t.Error("device should be removed:", got, err)
+	}
+	// 删除后 user_code 可以重新使用
+	if err := s.CreateDevice(ctx, &dup); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLDeviceStore(t *testing.T) {
+	s, _ := newSQLiteTokenStore(t)
+	testDeviceStore(t, s)
+
+	// 由唯一索引判断重复, device_code 冲突不是 user_code 重复, 不能重试
+	d := &storage.DeviceAuthorization{
+		DeviceCode: "device_2",
+		UserCode:   "LMNPQRST",
+		Status:     storage.DeviceStatusPending,
+		ExpiresAt:  time.Now().Add(time.Minute),
+	}
+	if err := s.CreateDevice(context.Background(), d); err == nil || err == storage.ErrDuplicateUserCode {
+		t.Error("duplicate device_code should fail with the database error:", err)
+	}
+}
+
+func TestRedisDeviceStore(t *testing.T) {
+	s, _ := newRedisTokenStore(t, "test:")
+	testDeviceStore(t, s)
+}
+
+func TestMemoryDeviceStore(t *testing.T) {
+	testDeviceStore(t, storage.NewMemoryDeviceStore())
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// JTIStore 记录已经使用过的 JWT ID, 防止 client assertion 被重放 (RFC 7523 3)
+type JTIStore interface {
+	// UseJTI 记录 jti 直到 expiresAt, 已经使用过时返回 false
+	// 不同签发方的 jti 可能相同, 调用方需要把签发方一起计算到 jti 中, 长度不超过255
+	UseJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryJTIStore 保存在进程内存中的 JTIStore, 与内存 token 存储一起使用
+type MemoryJTIStore struct {
+	mu        sync.Mutex
+	jtis      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryJTIStore 创建内存 JTIStore
+func NewMemoryJTIStore() *MemoryJTIStore {
+	return &MemoryJTIStore{jtis: make(map[string]time.Time)}
+}
+
+// UseJTI 实现 JTIStore
+func (s *MemoryJTIStore) UseJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	if exp, ok := s.jtis[jti]; ok && exp.After(time.Now()) {
+		return false, nil
+	}
+	s.jtis[jti] = expiresAt
+	return true, nil
+}
+
+// sweep 每分钟最多清理一次过期的记录, 调用方需要持有锁
+func (s *MemoryJTIStore) sweep() {
+	t := time.Now()
+	if t.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = t
+	for jti, exp := range s.jtis {
+		if !exp.After(t) {
+			delete(s.jtis, jti)
+		}
+	}
+}
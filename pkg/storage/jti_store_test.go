@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"context"
+	"oauth2/pkg/storage"
+	"testing"
+	"time"
+)
+
+func testJTIStore(t *testing.T, s storage.JTIStore, expire func()) {
+	ctx := context.Background()
+	if ok, err := s.UseJTI(ctx, "jti_1", time.Now().Add(time.Minute)); err != nil || !ok {
+		t.Fatal("first use should succeed:", ok, err)
+	}
+	if ok, err := s.UseJTI(ctx, "jti_1", time.Now().Add(time.Minute)); err != nil || ok {
+		t.Error("replayed jti should be rejected:", ok, err)
+	}
+	if ok, err := s.UseJTI(ctx, "jti_2", time.Now().Add(time.Minute)); err != nil || !ok {
+		t.Error("other jti should succeed:", ok, err)
+	}
+
+	// 过期之后可以再次记录
+	if ok, err := s.UseJTI(ctx, "jti_3", time.Now().Add(time.Second)); err != nil || !ok {
+		t.Fatal("first use should succeed:", ok, err)
+	}
+	if expire != nil {
+		expire()
+	} else {
+		time.Sleep(1100 * time.Millisecond)
+	}
+	if ok, err := s.UseJTI(ctx, "jti_3", time.Now().Add(time.Minute)); err != nil || !ok {
+		t.Error("expired jti should be usable again:", ok, err)
+	}
+}
+
+func TestSQLJTIStore(t *testing.T) {
+	s, _ := newSQLiteTokenStore(t)
+	testJTIStore(t, s, nil)
+}
+
+func TestRedisJTIStore(t *testing.T) {
+	s, mr := newRedisTokenStore(t, "test:")
+	testJTIStore(t, s, func() { mr.FastForward(2 * time.Second) })
+}
+
+func TestMemoryJTIStore(t *testing.T) {
+	testJTIStore(t, storage.NewMemoryJTIStore(), nil)
+}
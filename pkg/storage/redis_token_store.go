@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisTokenStore 实现基于Redis的Token存储
+// 使用redis自带的过期时间, 不需要定时清理
+//
+// key的结构:
+//
+//	{prefix}code:{code}       -> token数据
+//	{prefix}basic:{id}        -> token数据
+//	{prefix}access:{access}   -> id
+//	{prefix}refresh:{refresh} -> id
+//	{prefix}family:{id}       -> hash, refresh token -> 是否已使用(1/0)
+//	{prefix}family_refresh:{refresh} -> family id
+//	{prefix}device:{device_code}     -> 设备授权请求
+//	{prefix}device_user:{user_code}  -> device_code
+//	{prefix}jti:{jti}                -> 已使用的 client assertion
+type RedisTokenStore struct {
+	cli    redis.UniversalClient
+	prefix string
+}
+
+// NewRedisTokenStore 创建Redis Token存储实例
+// keyPrefix 用于多个部署共用一个redis时区分各自的key
+func NewRedisTokenStore(cli redis.UniversalClient, keyPrefix string) *RedisTokenStore {
+	return &RedisTokenStore{
+		cli:    cli,
+		prefix: keyPrefix,
+	}
+}
+
+func (s *RedisTokenStore) key(kind, value string) string {
+	return s.prefix + kind + ":" + value
+}
+
+// ttl 计算剩余有效期, 0表示不过期
+func ttl(createAt time.Time, expiresIn time.Duration) time.Duration {
+	if expiresIn <= 0 {
+		return 0
+	}
+	d := time.Until(createAt.Add(expiresIn))
+	if d <= 0 {
+		// 已经过期的也要写入, 给一个极短的有效期
+		d = time.Millisecond
+	}
+	return d
+}
+
+// Create 创建并存储Token信息
+func (s *RedisTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	if code := info.GetCode(); code != "" {
+		return s.cli.Set(ctx, s.key("code", code), data, ttl(info.GetCodeCreateAt(), info.GetCodeExpiresIn())).Err()
+	}
+
+	basicID := uuid.Must(uuid.NewRandom()).String()
+	aexp := ttl(info.GetAccessCreateAt(), info.GetAccessExpiresIn())
+	rexp := aexp
+	if refresh := info.GetRefresh(); refresh != "" {
+		rexp = ttl(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
+		// access 不能比 refresh 活得更久
+		if rexp > 0 && (aexp == 0 || aexp > rexp) {
+			aexp = rexp
+		}
+	}
+
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key("basic", basicID), data, rexp)
+		if access := info.GetAccess(); access != "" {
+			pipe.Set(ctx, s.key("access", access), basicID, aexp)
+		}
+		if refresh := info.GetRefresh(); refresh != "" {
+			pipe.Set(ctx, s.key("refresh", refresh), basicID, rexp)
+		}
+		return nil
+	})
+	return err
+}
+
+// RemoveByCode 根据授权码删除Token信息
+func (s *RedisTokenStore) RemoveByCode(ctx context.Context, code string) error {
+	return s.cli.Del(ctx, s.key("code", code)).Err()
+}
+
+// RemoveByAccess 根据Access Token删除Token信息
+func (s *RedisTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	return s.cli.Del(ctx, s.key("access", access)).Err()
+}
+
+// RemoveByRefresh 根据Refresh Token删除Token信息
+func (s *RedisTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	return s.cli.Del(ctx, s.key("refresh", refresh)).Err()
+}
+
+// GetByCode 根据授权码获取Token信息
+func (s *RedisTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return s.getToken(ctx, s.key("code", code))
+}
+
+// GetByAccess 根据Access Token获取Token信息
+func (s *RedisTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return s.getTokenByID(ctx, s.key("access", access))
+}
+
+// GetByRefresh 根据Refresh Token获取Token信息
+func (s *RedisTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.getTokenByID(ctx, s.key("refresh", refresh))
+}
+
+// getTokenByID 先通过idKey拿到basicID, 再获取Token信息
+func (s *RedisTokenStore) getTokenByID(ctx context.Context, idKey string) (oauth2.TokenInfo, error) {
+	basicID, err := s.cli.Get(ctx, idKey).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.getToken(ctx, s.key("basic", basicID))
+}
+
+func (s *RedisTokenStore) getToken(ctx context.Context, key string) (oauth2.TokenInfo, error) {
+	data, err := s.cli.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var token models.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ListTokens 实现 TokenLister
+// 遍历全部 basic key 后在内存中过滤和分页, 只适合管理后台这类低频的调用
+func (s *RedisTokenStore) ListTokens(ctx context.Context, filter TokenFilter, offset, limit int) ([]oauth2.TokenInfo, int64, error) {
+	var matched []oauth2.TokenInfo
+	iter := s.cli.Scan(ctx, 0, s.key("basic", "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		ti, err := s.getToken(ctx, iter.Val())
+		if err != nil {
+			return nil, 0, err
+		}
+		if ti == nil ||
+			(filter.ClientID != "" && ti.GetClientID() != filter.ClientID) ||
+			(filter.UserID != "" && ti.GetUserID() != filter.UserID) {
+			continue
+		}
+		// access 和 refresh 都被撤销或过期的不再列出
+		n, err := s.cli.Exists(ctx, s.key("access", ti.GetAccess()), s.key("refresh", ti.GetRefresh())).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		if n > 0 {
+			matched = append(matched, ti)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].GetAccessCreateAt().After(matched[j].GetAccessCreateAt())
+	})
+	total := int64(len(matched))
+	if offset >= len(matched) {
+		return make([]oauth2.TokenInfo, 0), total, nil
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[offset:end], total, nil
+}
+
+// familyTTL family 记录的有效期, 0表示不过期
+func familyTTL(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	d := time.Until(expiresAt)
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// RotateRefresh 实现 RefreshFamilyStore
+func (s *RedisTokenStore) RotateRefresh(ctx context.Context, familyID, refresh string, expiresAt time.Time) error {
+	familyKey := s.key("family", familyID)
+	used, err := s.cli.HKeys(ctx, familyKey).Result()
+	if err != nil {
+		return err
+	}
+	exp := familyTTL(expiresAt)
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// 之前的 refresh token 都标记为已使用, 并与 family 一起过期
+		for _, v := range used {
+			pipe.HSet(ctx, familyKey, v, "1")
+			if exp > 0 {
+				pipe.PExpire(ctx, s.key("family_refresh", v), exp)
+			} else {
+				pipe.Persist(ctx, s.key("family_refresh", v))
+			}
+		}
+		pipe.HSet(ctx, familyKey, refresh, "0")
+		pipe.Set(ctx, s.key("family_refresh", refresh), familyID, exp)
+		if exp > 0 {
+			pipe.PExpire(ctx, familyKey, exp)
+		} else {
+			pipe.Persist(ctx, familyKey)
+		}
+		return nil
+	})
+	return err
+}
+
+// UseRefresh 实现 RefreshFamilyStore
+// 使用 WATCH 检查标记, 并发的请求中只有一个能修改成功
+func (s *RedisTokenStore) UseRefresh(ctx context.Context, refresh string) (bool, error) {
+	familyID, err := s.cli.Get(ctx, s.key("family_refresh", refresh)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	familyKey := s.key("family", familyID)
+	used := false
+	err = s.cli.Watch(ctx, func(tx *redis.Tx) error {
+		v, err := tx.HGet(ctx, familyKey, refresh).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			return err
+		}
+		if v != "0" {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, familyKey, refresh, "1")
+			return nil
+		})
+		used = err == nil
+		return err
+	}, familyKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	return used, err
+}
+
+// GetRefreshFamily 实现 RefreshFamilyStore
+func (s *RedisTokenStore) GetRefreshFamily(ctx context.Context, refresh string) (string, bool, error) {
+	familyID, err := s.cli.Get(ctx, s.key("family_refresh", refresh)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	v, err := s.cli.HGet(ctx, s.key("family", familyID), refresh).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return familyID, v == "1", nil
+}
+
+// CurrentRefresh 实现 RefreshFamilyStore
+func (s *RedisTokenStore) CurrentRefresh(ctx context.Context, familyID string) (string, error) {
+	refreshes, err := s.cli.HGetAll(ctx, s.key("family", familyID)).Result()
+	if err != nil {
+		return "", err
+	}
+	for refresh, used := range refreshes {
+		if used == "0" {
+			return refresh, nil
+		}
+	}
+	return "", nil
+}
+
+// RemoveRefreshFamily 实现 RefreshFamilyStore
+func (s *RedisTokenStore) RemoveRefreshFamily(ctx context.Context, familyID string) error {
+	familyKey := s.key("family", familyID)
+	refreshes, err := s.cli.HKeys(ctx, familyKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{familyKey}
+	for _, refresh := range refreshes {
+		keys = append(keys, s.key("family_refresh", refresh))
+	}
+	return s.cli.Del(ctx, keys...).Err()
+}
+
+// CreateDevice 实现 DeviceStore
+func (s *RedisTokenStore) CreateDevice(ctx context.Context, d *DeviceAuthorization) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	exp := time.Until(d.ExpiresAt.Add(DeviceRetention))
+	ok, err := s.cli.SetNX(ctx, s.key("device_user", d.UserCode), d.DeviceCode, exp).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDuplicateUserCode
+	}
+	return s.cli.Set(ctx, s.key("device", d.DeviceCode), data, exp).Err()
+}
+
+// UpdateDevice 实现 DeviceStore
+// 使用 WATCH 检查状态, 读取之后被其他请求修改时不更新
+func (s *RedisTokenStore) UpdateDevice(ctx context.Context, d *DeviceAuthorization, status string) (bool, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return false, err
+	}
+	key := s.key("device", d.DeviceCode)
+	updated := false
+	err = s.cli.Watch(ctx, func(tx *redis.Tx) error {
+		old, err := tx.Get(ctx, key).Bytes()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			return err
+		}
+		var cur DeviceAuthorization
+		if err := json.Unmarshal(old, &cur); err != nil {
+			return err
+		}
+		if cur.Status != status {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, data, redis.SetArgs{Mode: "XX", KeepTTL: true})
+			return nil
+		})
+		updated = err == nil
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	return updated, err
+}
+
+// GetDeviceByDeviceCode 实现 DeviceStore
+func (s *RedisTokenStore) GetDeviceByDeviceCode(ctx context.Context, deviceCode string) (*DeviceAuthorization, error) {
+	data, err := s.cli.Get(ctx, s.key("device", deviceCode)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	d := new(DeviceAuthorization)
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GetDeviceByUserCode 实现 DeviceStore
+func (s *RedisTokenStore) GetDeviceByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	deviceCode, err := s.cli.Get(ctx, s.key("device_user", userCode)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.GetDeviceByDeviceCode(ctx, deviceCode)
+}
+
+// RemoveDevice 实现 DeviceStore
+func (s *RedisTokenStore) RemoveDevice(ctx context.Context, deviceCode string) (bool, error) {
+	d, err := s.GetDeviceByDeviceCode(ctx, deviceCode)
+	if err != nil || d == nil {
+		return false, err
+	}
+	n, err := s.cli.Del(ctx, s.key("device", deviceCode)).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, s.cli.Del(ctx, s.key("device_user", d.UserCode)).Err()
+}
+
+// UseJTI 实现 JTIStore
+func (s *RedisTokenStore) UseJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	exp := time.Until(expiresAt)
+	if exp <= 0 {
+		return false, nil
+	}
+	return s.cli.SetNX(ctx, s.key("jti", jti), 1, exp).Result()
+}
@@ -0,0 +1,154 @@
+package storage_test
+
+import (
+	"context"
+	"oauth2/pkg/storage"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisTokenStore(t *testing.T, prefix string) (*storage.RedisTokenStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return storage.NewRedisTokenStore(cli, prefix), mr
+}
+
+func TestRedisTokenStoreCode(t *testing.T) {
+	s, mr := newRedisTokenStore(t, "test:")
+	ctx := context.Background()
+
+	code := models.NewToken()
+	code.SetClientID("app_1")
+	code.SetUserID("1")
+	code.SetCode("code_1")
+	code.SetCodeCreateAt(time.Now())
+	code.SetCodeExpiresIn(time.Minute)
+	if err := s.Create(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("test:code:code_1") {
+		t.Error("code key should use prefix")
+	}
+
+	ti, err := s.GetByCode(ctx, "code_1")
+	if err != nil || ti == nil || ti.GetUserID() != "1" {
+		t.Fatal("get by code failed:", ti, err)
+	}
+	if err := s.RemoveByCode(ctx, "code_1"); err != nil {
+		t.Fatal(err)
+	}
+	if ti, _ := s.GetByCode(ctx, "code_1"); ti != nil {
+		t.Error("code should be removed")
+	}
+
+	if err := s.Create(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(2 * time.Minute)
+	if ti, _ := s.GetByCode(ctx, "code_1"); ti != nil {
+		t.Error("code should be expired")
+	}
+}
+
+func TestRedisTokenStoreAccessRefresh(t *testing.T) {
+	s, mr := newRedisTokenStore(t, "")
+	ctx := context.Background()
+
+	now := time.Now()
+	token := models.NewToken()
+	token.SetClientID("app_1")
+	token.SetUserID("1")
+	token.SetAccess("access_1")
+	token.SetAccessCreateAt(now)
+	token.SetAccessExpiresIn(time.Hour)
+	token.SetRefresh("refresh_1")
+	token.SetRefreshCreateAt(now)
+	token.SetRefreshExpiresIn(24 * time.Hour)
+	if err := s.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	if ti, err := s.GetByAccess(ctx, "access_1"); err != nil || ti == nil || ti.GetRefresh() != "refresh_1" {
+		t.Fatal("get by access failed:", ti, err)
+	}
+	if ti, err := s.GetByRefresh(ctx, "refresh_1"); err != nil || ti == nil || ti.GetAccess() != "access_1" {
+		t.Fatal("get by refresh failed:", ti, err)
+	}
+
+	// access过期后refresh依然可用
+	mr.FastForward(2 * time.Hour)
+	if ti, _ := s.GetByAccess(ctx, "access_1"); ti != nil {
+		t.Error("access should be expired")
+	}
+	if ti, _ := s.GetByRefresh(ctx, "refresh_1"); ti == nil {
+		t.Error("refresh should still be valid")
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh_1"); err != nil {
+		t.Fatal(err)
+	}
+	if ti, _ := s.GetByRefresh(ctx, "refresh_1"); ti != nil {
+		t.Error("refresh should be removed")
+	}
+}
+
+func TestRedisTokenStorePrefix(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer cli.Close()
+	a := storage.NewRedisTokenStore(cli, "a:")
+	b := storage.NewRedisTokenStore(cli, "b:")
+	ctx := context.Background()
+
+	token := models.NewToken()
+	token.SetAccess("access_1")
+	token.SetAccessCreateAt(time.Now())
+	token.SetAccessExpiresIn(time.Hour)
+	if err := a.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if ti, _ := a.GetByAccess(ctx, "access_1"); ti == nil {
+		t.Error("token should exist under prefix a")
+	}
+	if ti, _ := b.GetByAccess(ctx, "access_1"); ti != nil {
+		t.Error("token should not be visible under prefix b")
+	}
+}
+
+func TestRedisTokenStoreListTokens(t *testing.T) {
+	s, _ := newRedisTokenStore(t, "test:")
+	ctx := context.Background()
+
+	now := time.Now()
+	for i, client := range []string{"app_1", "app_1", "app_2"} {
+		token := models.NewToken()
+		token.SetClientID(client)
+		token.SetUserID("1")
+		token.SetAccess("access_" + strconv.Itoa(i))
+		token.SetAccessCreateAt(now.Add(time.Duration(i) * time.Second))
+		token.SetAccessExpiresIn(time.Hour)
+		if err := s.Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens, total, err := s.ListTokens(ctx, storage.TokenFilter{ClientID: "app_1"}, 0, 10)
+	if err != nil || total != 2 || len(tokens) != 2 || tokens[0].GetAccess() != "access_1" {
+		t.Fatal("list by client failed:", total, tokens, err)
+	}
+
+	// 撤销后不再列出
+	if err := s.RemoveByAccess(ctx, "access_1"); err != nil {
+		t.Fatal(err)
+	}
+	tokens, total, err = s.ListTokens(ctx, storage.TokenFilter{UserID: "1"}, 1, 10)
+	if err != nil || total != 2 || len(tokens) != 1 || tokens[0].GetAccess() != "access_0" {
+		t.Error("list by user failed:", total, tokens, err)
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RefreshFamilyStore 记录 refresh token 的轮换关系, 用于发现被盗用的 refresh token
+// 同一次授权不断刷新得到的 refresh token 属于同一个 family,
+// 只有最新的一个可以使用, 之前的都已经用过; 用过的再次出现说明 token 可能泄露
+type RefreshFamilyStore interface {
+	// RotateRefresh 把 refresh 记为 family 最新的 refresh token, 之前的标记为已使用
+	// family 的全部记录保留到 expiresAt, 零值表示不过期
+	RotateRefresh(ctx context.Context, familyID, refresh string, expiresAt time.Time) error
+	// UseRefresh 签发新的 refresh token 之前把 refresh 标记为已使用
+	// 只有它是 family 最新的且还没有被使用时返回 true, 同一个 refresh token 并发刷新时只有一个成功
+	UseRefresh(ctx context.Context, refresh string) (bool, error)
+	// GetRefreshFamily 查找 refresh token 所属的 family, 没有记录时 familyID 为空
+	GetRefreshFamily(ctx context.Context, refresh string) (familyID string, used bool, err error)
+	// CurrentRefresh family 最新的 refresh token, 没有时返回空
+	CurrentRefresh(ctx context.Context, familyID string) (string, error)
+	// RemoveRefreshFamily 删除 family 的全部记录
+	RemoveRefreshFamily(ctx context.Context, familyID string) error
+}
+
+// MemoryRefreshFamilyStore 保存在进程内存中的 RefreshFamilyStore, 与内存 token 存储一起使用
+type MemoryRefreshFamilyStore struct {
+	mu       sync.Mutex
+	families map[string]*memoryFamily
+	// refresh token -> family id
+	index     map[string]string
+	lastSweep time.Time
+}
+
+type memoryFamily struct {
+	current string
+	// current 已经被使用, 新的 refresh token 还没有保存
+	used      bool
+	refreshes []string
+	expiresAt time.Time
+}
+
+func (f *memoryFamily) expired(t time.Time) bool {
+	return !f.expiresAt.IsZero() && !f.expiresAt.After(t)
+}
+
+// NewMemoryRefreshFamilyStore 创建内存 RefreshFamilyStore
+func NewMemoryRefreshFamilyStore() *MemoryRefreshFamilyStore {
+	return &MemoryRefreshFamilyStore{
+		families: make(map[string]*memoryFamily),
+		index:    make(map[string]string),
+	}
+}
+
+// RotateRefresh 实现 RefreshFamilyStore
+func (s *MemoryRefreshFamilyStore) RotateRefresh(ctx context.Context, familyID, refresh string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	f := s.families[familyID]
+	if f == nil {
+		f = new(memoryFamily)
+		s.families[familyID] = f
+	}
+	f.current = refresh
+	f.used = false
+	f.refreshes = append(f.refreshes, refresh)
+	f.expiresAt = expiresAt
+	s.index[refresh] = familyID
+	return nil
+}
+
+// UseRefresh 实现 RefreshFamilyStore
+func (s *MemoryRefreshFamilyStore) UseRefresh(ctx context.Context, refresh string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.families[s.index[refresh]]
+	if f == nil || f.expired(time.Now()) || f.current != refresh || f.used {
+		return false, nil
+	}
+	f.used = true
+	return true, nil
+}
+
+// GetRefreshFamily 实现 RefreshFamilyStore
+func (s *MemoryRefreshFamilyStore) GetRefreshFamily(ctx context.Context, refresh string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	familyID := s.index[refresh]
+	f := s.families[familyID]
+	if f == nil || f.expired(time.Now()) {
+		return "", false, nil
+	}
+	return familyID, f.current != refresh || f.used, nil
+}
+
+// CurrentRefresh 实现 RefreshFamilyStore
+func (s *MemoryRefreshFamilyStore) CurrentRefresh(ctx context.Context, familyID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.families[familyID]
+	if f == nil || f.expired(time.Now()) || f.used {
+		return "", nil
+	}
+	return f.current, nil
+}
+
+// RemoveRefreshFamily 实现 RefreshFamilyStore
+func (s *MemoryRefreshFamilyStore) RemoveRefreshFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(familyID)
+	return nil
+}
+
+func (s *MemoryRefreshFamilyStore) remove(familyID string) {
+	if f := s.families[familyID]; f != nil {
+		for _, refresh := range f.refreshes {
+			delete(s.index, refresh)
+		}
+		delete(s.families, familyID)
+	}
+}
+
+// sweep 每分钟最多清理一次过期的 family, 调用方需要持有锁
+func (s *MemoryRefreshFamilyStore) sweep() {
+	t := time.Now()
+	if t.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = t
+	for id, f := range s.families {
+		if f.expired(t) {
+			s.remove(id)
+		}
+	}
+}
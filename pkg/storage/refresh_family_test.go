@@ -0,0 +1,96 @@
+package storage_test
+
+import (
+	"context"
+	"oauth2/pkg/storage"
+	"testing"
+	"time"
+)
+
+// testRefreshFamilyStore expire 用于让 miniredis 中的 key 过期, 其他存储为nil
+func testRefreshFamilyStore(t *testing.T, s storage.RefreshFamilyStore, expire func()) {
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour)
+
+	if err := s.RotateRefresh(ctx, "family_1", "refresh_1", exp); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateRefresh(ctx, "family_1", "refresh_2", exp); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateRefresh(ctx, "family_2", "refresh_3", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, used, err := s.GetRefreshFamily(ctx, "refresh_1"); err != nil || id != "family_1" || !used {
+		t.Error("refresh_1 should be used:", id, used, err)
+	}
+	if id, used, err := s.GetRefreshFamily(ctx, "refresh_2"); err != nil || id != "family_1" || used {
+		t.Error("refresh_2 should be current:", id, used, err)
+	}
+	if id, _, err := s.GetRefreshFamily(ctx, "unknown"); err != nil || id != "" {
+		t.Error("unknown refresh token:", id, err)
+	}
+	if current, err := s.CurrentRefresh(ctx, "family_1"); err != nil || current != "refresh_2" {
+		t.Error("unexpected current refresh token:", current, err)
+	}
+
+	// 只有最新的 refresh token 可以使用, 并且只能使用一次
+	if ok, err := s.UseRefresh(ctx, "refresh_1"); err != nil || ok {
+		t.Error("used refresh token should not be used again:", ok, err)
+	}
+	if ok, err := s.UseRefresh(ctx, "refresh_3"); err != nil || !ok {
+		t.Fatal("use refresh token failed:", ok, err)
+	}
+	if ok, err := s.UseRefresh(ctx, "refresh_3"); err != nil || ok {
+		t.Error("refresh token should be used only once:", ok, err)
+	}
+	if _, used, err := s.GetRefreshFamily(ctx, "refresh_3"); err != nil || !used {
+		t.Error("refresh_3 should be used:", used, err)
+	}
+	if ok, err := s.UseRefresh(ctx, "unknown"); err != nil || ok {
+		t.Error("unknown refresh token:", ok, err)
+	}
+	if err := s.RotateRefresh(ctx, "family_2", "refresh_5", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveRefreshFamily(ctx, "family_1"); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := s.GetRefreshFamily(ctx, "refresh_1"); err != nil || id != "" {
+		t.Error("family should be removed:", id, err)
+	}
+	if current, err := s.CurrentRefresh(ctx, "family_1"); err != nil || current != "" {
+		t.Error("family should be removed:", current, err)
+	}
+	// 不影响其他 family
+	if current, err := s.CurrentRefresh(ctx, "family_2"); err != nil || current != "refresh_5" {
+		t.Error("unexpected current refresh token:", current, err)
+	}
+
+	// 过期的 family
+	if err := s.RotateRefresh(ctx, "family_3", "refresh_4", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if expire != nil {
+		expire()
+	}
+	if id, _, err := s.GetRefreshFamily(ctx, "refresh_4"); err != nil || id != "" {
+		t.Error("expired family returned:", id, err)
+	}
+}
+
+func TestSQLRefreshFamilyStore(t *testing.T) {
+	s, _ := newSQLiteTokenStore(t)
+	testRefreshFamilyStore(t, s, nil)
+}
+
+func TestRedisRefreshFamilyStore(t *testing.T) {
+	s, mr := newRedisTokenStore(t, "test:")
+	testRefreshFamilyStore(t, s, func() { mr.FastForward(time.Second) })
+}
+
+func TestMemoryRefreshFamilyStore(t *testing.T) {
+	testRefreshFamilyStore(t, storage.NewMemoryRefreshFamilyStore(), nil)
+}
@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// TokenFilter 列出token时的过滤条件, 为空的字段不过滤
+type TokenFilter struct {
+	ClientID string
+	UserID   string
+}
+
+// TokenLister 可以按客户端或用户列出有效token的存储
+// 只列出 access 或 refresh 还有效的token, 不包括授权码, 按创建时间倒序
+type TokenLister interface {
+	ListTokens(ctx context.Context, filter TokenFilter, offset, limit int) (tokens []oauth2.TokenInfo, total int64, err error)
+}
@@ -5,30 +5,71 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/models"
 )
 
-// MySQLTokenStore 实现基于MySQL的Token存储
-type MySQLTokenStore struct {
+// 支持的数据库类型, 与配置中 db.type 一致
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// SQLTokenStore 实现基于关系型数据库的Token存储
+// 支持 MySQL, PostgreSQL 和 SQLite, 建表语句和占位符按 dialect 区分
+type SQLTokenStore struct {
 	db        *sql.DB
+	dialect   string
 	tableName string
 }
 
-// NewMySQLTokenStore 创建MySQL Token存储实例
-func NewMySQLTokenStore(db *sql.DB, tableName string) *MySQLTokenStore {
+// NewSQLTokenStore 创建Token存储实例, dialect 为空时按MySQL处理
+func NewSQLTokenStore(db *sql.DB, dialect, tableName string) *SQLTokenStore {
 	if tableName == "" {
 		tableName = "oauth2_tokens"
 	}
-	return &MySQLTokenStore{
+	if dialect == "" {
+		dialect = DialectMySQL
+	}
+	return &SQLTokenStore{
 		db:        db,
+		dialect:   dialect,
 		tableName: tableName,
 	}
 }
 
-func (s *MySQLTokenStore) StartTicker(ctx context.Context, t time.Duration) {
+// rebind 把 ? 占位符转换为当前数据库的格式, PostgreSQL 使用 $1, $2 ...
+func (s *SQLTokenStore) rebind(query string) string {
+	if s.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *SQLTokenStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLTokenStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLTokenStore) StartTicker(ctx context.Context, t time.Duration) {
 	if t <= 1*time.Second {
 		t = 5 * time.Second
 	}
@@ -49,115 +90,599 @@ func (s *MySQLTokenStore) StartTicker(ctx context.Context, t time.Duration) {
 	}()
 }
 
+// neverExpire 用于过期时长为0(不过期)的token
+var neverExpire = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// expiresAt 计算过期时间, expiresIn<=0表示不过期
+// 统一使用UTC, SQLite 按字符串比较时间, 时区不一致会比较出错
+func expiresAt(createAt time.Time, expiresIn time.Duration) time.Time {
+	if expiresIn <= 0 {
+		return neverExpire
+	}
+	return createAt.Add(expiresIn).UTC()
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
 // Create 创建并存储Token信息
-func (s *MySQLTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+// 授权码单独存一行, 使用授权码自己的过期时间;
+// access/refresh 存在同一行, 分别记录过期时间
+func (s *SQLTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
 	data, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
 
-	query := `INSERT INTO ` + s.tableName + ` (access_token, refresh_token, data, expires_at, created_at) 
-              VALUES (?, ?, ?, ?, ?)`
+	query := `INSERT INTO ` + s.tableName + ` (client_id, user_id, access_token, refresh_token, code, data, expires_at, refresh_expires_at, created_at) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err = s.db.ExecContext(ctx, query,
+	if code := info.GetCode(); code != "" {
+		_, err = s.exec(ctx, query,
+			info.GetClientID(),
+			info.GetUserID(),
+			"",
+			nil,
+			code,
+			string(data),
+			expiresAt(info.GetCodeCreateAt(), info.GetCodeExpiresIn()),
+			nil,
+			now(),
+		)
+		return err
+	}
+
+	var refresh, refreshExpiresAt interface{}
+	if v := info.GetRefresh(); v != "" {
+		refresh = v
+		refreshExpiresAt = expiresAt(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
+	}
+	_, err = s.exec(ctx, query,
+		info.GetClientID(),
+		info.GetUserID(),
 		info.GetAccess(),
-		info.GetRefresh(),
-		data,
-		info.GetAccessCreateAt().Add(info.GetAccessExpiresIn()),
-		time.Now(),
+		refresh,
+		nil,
+		string(data),
+		expiresAt(info.GetAccessCreateAt(), info.GetAccessExpiresIn()),
+		refreshExpiresAt,
+		now(),
 	)
 	return err
 }
 
 // RemoveByAccess 根据Access Token删除Token信息
-func (s *MySQLTokenStore) RemoveByAccess(ctx context.Context, access string) error {
-	query := `DELETE FROM ` + s.tableName + ` WHERE access_token = ?`
-	_, err := s.db.ExecContext(ctx, query, access)
+// 同一行上还有refresh token时只作废access token, refresh token依然可用
+func (s *SQLTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	if access == "" {
+		return nil
+	}
+	query := `UPDATE ` + s.tableName + ` SET access_token = '', expires_at = ? 
+              WHERE access_token = ? AND refresh_token IS NOT NULL AND refresh_token <> ''`
+	if _, err := s.exec(ctx, query, now(), access); err != nil {
+		return err
+	}
+	query = `DELETE FROM ` + s.tableName + ` WHERE access_token = ?`
+	_, err := s.exec(ctx, query, access)
 	return err
 }
 
 // RemoveByRefresh 根据Refresh Token删除Token信息
-func (s *MySQLTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+func (s *SQLTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	if refresh == "" {
+		return nil
+	}
 	query := `DELETE FROM ` + s.tableName + ` WHERE refresh_token = ?`
-	_, err := s.db.ExecContext(ctx, query, refresh)
+	_, err := s.exec(ctx, query, refresh)
 	return err
 }
 
 // GetByAccess 根据Access Token获取Token信息
-func (s *MySQLTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
-	return s.getTokenByField(ctx, "access_token", access)
+func (s *SQLTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return s.getTokenByField(ctx, "access_token", "expires_at", access)
 }
 
 // GetByRefresh 根据Refresh Token获取Token信息
-func (s *MySQLTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
-	return s.getTokenByField(ctx, "refresh_token", refresh)
+// 使用refresh token自己的过期时间, access过期不影响刷新
+func (s *SQLTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.getTokenByField(ctx, "refresh_token", "refresh_expires_at", refresh)
 }
 
 // GetByCode 根据授权码获取Token信息
-func (s *MySQLTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
-	return s.getTokenByField(ctx, "code", code)
+// 授权码只能使用一次, 第一次读取时就会被标记为已使用,
+// 并发的兑换请求只有一个能拿到数据
+func (s *SQLTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	if code == "" {
+		return nil, nil
+	}
+	t := now()
+	query := `UPDATE ` + s.tableName + ` SET consumed_at = ? 
+              WHERE code = ? AND consumed_at IS NULL AND expires_at > ?`
+	res, err := s.exec(ctx, query, t, code, t)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	var data []byte
+	query = `SELECT data FROM ` + s.tableName + ` WHERE code = ?`
+	if err := s.queryRow(ctx, query, code).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return unmarshalToken(data)
 }
 
 // RemoveByCode 根据授权码删除Token信息
-func (s *MySQLTokenStore) RemoveByCode(ctx context.Context, code string) error {
+func (s *SQLTokenStore) RemoveByCode(ctx context.Context, code string) error {
+	if code == "" {
+		return nil
+	}
 	query := `DELETE FROM ` + s.tableName + ` WHERE code = ?`
-	_, err := s.db.ExecContext(ctx, query, code)
+	_, err := s.exec(ctx, query, code)
 	return err
 }
 
-// getTokenByField 根据字段获取Token信息
-func (s *MySQLTokenStore) getTokenByField(ctx context.Context, field, value string) (oauth2.TokenInfo, error) {
-	query := `SELECT data, expires_at FROM ` + s.tableName + ` WHERE ` + field + ` = ? AND expires_at > ?`
+// getTokenByField 根据字段获取未过期的Token信息
+func (s *SQLTokenStore) getTokenByField(ctx context.Context, field, expiresField, value string) (oauth2.TokenInfo, error) {
+	if value == "" {
+		return nil, nil
+	}
+	query := `SELECT data FROM ` + s.tableName + ` WHERE ` + field + ` = ? AND ` + expiresField + ` > ?`
 
 	var data []byte
-	var expiresAt time.Time
-
-	err := s.db.QueryRowContext(ctx, query, value, time.Now()).Scan(&data, &expiresAt)
+	err := s.queryRow(ctx, query, value, now()).Scan(&data)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	return unmarshalToken(data)
+}
 
+func unmarshalToken(data []byte) (oauth2.TokenInfo, error) {
 	var token models.Token
 	if err := json.Unmarshal(data, &token); err != nil {
 		return nil, err
 	}
-
-	// 如果token已过期，自动删除
-	if time.Now().After(expiresAt) {
-		s.RemoveByAccess(ctx, token.GetAccess())
-		return nil, nil
-	}
-
 	return &token, nil
 }
 
 // CreateTable 创建Token表
-func (s *MySQLTokenStore) CreateTable() error {
-	query := `
+func (s *SQLTokenStore) CreateTable() error {
+	var query string
+	switch s.dialect {
+	case DialectPostgres:
+		query = `
+	CREATE TABLE IF NOT EXISTS ` + s.tableName + ` (
+		id BIGSERIAL PRIMARY KEY,
+		client_id VARCHAR(255) NOT NULL DEFAULT '',
+		user_id VARCHAR(255) NOT NULL DEFAULT '',
+		access_token VARCHAR(255) NOT NULL,
+		refresh_token VARCHAR(255),
+		code VARCHAR(255),
+		data TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		refresh_expires_at TIMESTAMPTZ NULL,
+		consumed_at TIMESTAMPTZ NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`
+	case DialectSQLite:
+		query = `
+	CREATE TABLE IF NOT EXISTS ` + s.tableName + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		client_id VARCHAR(255) NOT NULL DEFAULT '',
+		user_id VARCHAR(255) NOT NULL DEFAULT '',
+		access_token VARCHAR(255) NOT NULL,
+		refresh_token VARCHAR(255),
+		code VARCHAR(255),
+		data TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		refresh_expires_at DATETIME NULL,
+		consumed_at DATETIME NULL,
+		created_at DATETIME NOT NULL
+	)`
+	default:
+		query = `
 	CREATE TABLE IF NOT EXISTS ` + s.tableName + ` (
 		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		client_id VARCHAR(255) NOT NULL DEFAULT '',
+		user_id VARCHAR(255) NOT NULL DEFAULT '',
 		access_token VARCHAR(255) NOT NULL,
 		refresh_token VARCHAR(255),
 		code VARCHAR(255),
 		data TEXT NOT NULL,
 		expires_at DATETIME NOT NULL,
+		refresh_expires_at DATETIME NULL,
+		consumed_at DATETIME NULL,
 		created_at DATETIME NOT NULL,
 		INDEX idx_access_token (access_token),
 		INDEX idx_refresh_token (refresh_token),
 		INDEX idx_code (code),
-		INDEX idx_expires_at (expires_at)
+		INDEX idx_expires_at (expires_at),
+		INDEX idx_client_id (client_id),
+		INDEX idx_user_id (user_id)
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 	`
-	_, err := s.db.Exec(query)
+	}
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	// 兼容旧版本创建的表
+	if err := s.addColumnIfNotExists("refresh_expires_at", s.timeType()+" NULL"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfNotExists("client_id", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfNotExists("user_id", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfNotExists("consumed_at", s.timeType()+" NULL"); err != nil {
+		return err
+	}
+	// MySQL 的索引在建表语句中, 其他数据库单独创建
+	if s.dialect != DialectMySQL {
+		for _, column := range []string{"access_token", "refresh_token", "code", "expires_at", "client_id", "user_id"} {
+			index := "idx_" + s.tableName + "_" + column
+			if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS ` + index + ` ON ` + s.tableName + ` (` + column + `)`); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.createFamilyTable(); err != nil {
+		return err
+	}
+	if err := s.createDeviceTable(); err != nil {
+		return err
+	}
+	return s.createJTITable()
+}
+
+// familyTable refresh token family 的表名
+func (s *SQLTokenStore) familyTable() string {
+	return s.tableName + "_family"
+}
+
+// createFamilyTable 创建 refresh token family 表, 每个用过或正在使用的 refresh token 一行
+func (s *SQLTokenStore) createFamilyTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS ` + s.familyTable() + ` (
+		refresh_token VARCHAR(255) NOT NULL PRIMARY KEY,
+		family_id VARCHAR(64) NOT NULL,
+		used SMALLINT NOT NULL DEFAULT 0,
+		expires_at ` + s.timeType() + ` NOT NULL`
+	if s.dialect == DialectMySQL {
+		query += `,
+		INDEX idx_family_id (family_id),
+		INDEX idx_expires_at (expires_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+	} else {
+		query += `
+	)`
+	}
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	if s.dialect != DialectMySQL {
+		for _, column := range []string{"family_id", "expires_at"} {
+			index := "idx_" + s.familyTable() + "_" + column
+			if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS ` + index + ` ON ` + s.familyTable() + ` (` + column + `)`); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// timeType 时间列的类型
+func (s *SQLTokenStore) timeType() string {
+	if s.dialect == DialectPostgres {
+		return "TIMESTAMPTZ"
+	}
+	return "DATETIME"
+}
+
+// addColumnIfNotExists 表中没有该列时添加
+func (s *SQLTokenStore) addColumnIfNotExists(column, definition string) error {
+	rows, err := s.db.Query(`SELECT ` + column + ` FROM ` + s.tableName + ` LIMIT 0`)
+	if err == nil {
+		return rows.Close()
+	}
+	_, err = s.db.Exec(`ALTER TABLE ` + s.tableName + ` ADD COLUMN ` + column + ` ` + definition)
 	return err
 }
 
+// ListTokens 实现 TokenLister
+func (s *SQLTokenStore) ListTokens(ctx context.Context, filter TokenFilter, offset, limit int) ([]oauth2.TokenInfo, int64, error) {
+	t := now()
+	where := ` WHERE code IS NULL AND (expires_at > ? OR refresh_expires_at > ?)`
+	args := []interface{}{t, t}
+	if filter.ClientID != "" {
+		where += ` AND client_id = ?`
+		args = append(args, filter.ClientID)
+	}
+	if filter.UserID != "" {
+		where += ` AND user_id = ?`
+		args = append(args, filter.UserID)
+	}
+
+	var total int64
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM `+s.tableName+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT data FROM ` + s.tableName + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	tokens := make([]oauth2.TokenInfo, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, err
+		}
+		ti, err := unmarshalToken(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		tokens = append(tokens, ti)
+	}
+	return tokens, total, rows.Err()
+}
+
+// deviceTable 设备授权请求的表名
+func (s *SQLTokenStore) deviceTable() string {
+	return s.tableName + "_device"
+}
+
+// createDeviceTable 创建设备授权请求表, expires_at 为过期后再保留 DeviceRetention 的时间
+func (s *SQLTokenStore) createDeviceTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS ` + s.deviceTable() + ` (
+		device_code VARCHAR(255) NOT NULL PRIMARY KEY,
+		user_code VARCHAR(32) NOT NULL UNIQUE,
+		status VARCHAR(16) NOT NULL,
+		data TEXT NOT NULL,
+		expires_at ` + s.timeType() + ` NOT NULL`
+	if s.dialect == DialectMySQL {
+		query += `,
+		INDEX idx_expires_at (expires_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+	} else {
+		query += `
+	)`
+	}
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	if s.dialect != DialectMySQL {
+		index := "idx_" + s.deviceTable() + "_expires_at"
+		if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS ` + index + ` ON ` + s.deviceTable() + ` (expires_at)`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateDevice 实现 DeviceStore
+func (s *SQLTokenStore) CreateDevice(ctx context.Context, d *DeviceAuthorization) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	// 先查询再插入有并发问题, 直接插入由 user_code 的唯一索引保证不重复
+	query := `INSERT INTO ` + s.deviceTable() + ` (device_code, user_code, status, data, expires_at) VALUES (?, ?, ?, ?, ?)`
+	_, err = s.exec(ctx, query, d.DeviceCode, d.UserCode, d.Status, string(data), d.ExpiresAt.Add(DeviceRetention).UTC())
+	if err != nil && isDuplicateUserCode(err) {
+		return ErrDuplicateUserCode
+	}
+	return err
+}
+
+// isDuplicateUserCode 插入时是否违反了 user_code 的唯一索引
+// database/sql 没有统一的错误类型, 按各驱动的错误信息判断:
+// sqlite: UNIQUE constraint failed: xxx_device.user_code
+// mysql: Duplicate entry '...' for key 'xxx_device.user_code'
+// postgres: duplicate key value violates unique constraint "xxx_device_user_code_key"
+func isDuplicateUserCode(err error) bool {
+	msg := strings.ToLower(err.Error())
+	if !strings.Contains(msg, "unique constraint") && !strings.Contains(msg, "duplicate") {
+		return false
+	}
+	return strings.Contains(msg, "user_code")
+}
+
+// UpdateDevice 实现 DeviceStore
+// 状态单独存一列, 按状态条件更新
+func (s *SQLTokenStore) UpdateDevice(ctx context.Context, d *DeviceAuthorization, status string) (bool, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return false, err
+	}
+	query := `UPDATE ` + s.deviceTable() + ` SET status = ?, data = ? WHERE device_code = ? AND status = ?`
+	res, err := s.exec(ctx, query, d.Status, string(data), d.DeviceCode, status)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetDeviceByDeviceCode 实现 DeviceStore
+func (s *SQLTokenStore) GetDeviceByDeviceCode(ctx context.Context, deviceCode string) (*DeviceAuthorization, error) {
+	return s.getDevice(ctx, "device_code", deviceCode)
+}
+
+// GetDeviceByUserCode 实现 DeviceStore
+func (s *SQLTokenStore) GetDeviceByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	return s.getDevice(ctx, "user_code", userCode)
+}
+
+func (s *SQLTokenStore) getDevice(ctx context.Context, field, value string) (*DeviceAuthorization, error) {
+	var data []byte
+	query := `SELECT data FROM ` + s.deviceTable() + ` WHERE ` + field + ` = ? AND expires_at > ?`
+	if err := s.queryRow(ctx, query, value, now()).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	d := new(DeviceAuthorization)
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RemoveDevice 实现 DeviceStore
+func (s *SQLTokenStore) RemoveDevice(ctx context.Context, deviceCode string) (bool, error) {
+	res, err := s.exec(ctx, `DELETE FROM `+s.deviceTable()+` WHERE device_code = ?`, deviceCode)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RotateRefresh 实现 RefreshFamilyStore
+func (s *SQLTokenStore) RotateRefresh(ctx context.Context, familyID, refresh string, expiresAt time.Time) error {
+	if expiresAt.IsZero() {
+		expiresAt = neverExpire
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `UPDATE ` + s.familyTable() + ` SET used = 1, expires_at = ? WHERE family_id = ?`
+	if _, err := tx.ExecContext(ctx, s.rebind(query), expiresAt.UTC(), familyID); err != nil {
+		return err
+	}
+	query = `INSERT INTO ` + s.familyTable() + ` (refresh_token, family_id, used, expires_at) VALUES (?, ?, 0, ?)`
+	if _, err := tx.ExecContext(ctx, s.rebind(query), refresh, familyID, expiresAt.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRefresh 实现 RefreshFamilyStore
+func (s *SQLTokenStore) UseRefresh(ctx context.Context, refresh string) (bool, error) {
+	query := `UPDATE ` + s.familyTable() + ` SET used = 1 WHERE refresh_token = ? AND used = 0 AND expires_at > ?`
+	res, err := s.exec(ctx, query, refresh, now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetRefreshFamily 实现 RefreshFamilyStore
+func (s *SQLTokenStore) GetRefreshFamily(ctx context.Context, refresh string) (string, bool, error) {
+	var familyID string
+	var used int
+	query := `SELECT family_id, used FROM ` + s.familyTable() + ` WHERE refresh_token = ? AND expires_at > ?`
+	if err := s.queryRow(ctx, query, refresh, now()).Scan(&familyID, &used); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return familyID, used != 0, nil
+}
+
+// CurrentRefresh 实现 RefreshFamilyStore
+func (s *SQLTokenStore) CurrentRefresh(ctx context.Context, familyID string) (string, error) {
+	var refresh string
+	query := `SELECT refresh_token FROM ` + s.familyTable() + ` WHERE family_id = ? AND used = 0 AND expires_at > ?`
+	if err := s.queryRow(ctx, query, familyID, now()).Scan(&refresh); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return refresh, nil
+}
+
+// RemoveRefreshFamily 实现 RefreshFamilyStore
+func (s *SQLTokenStore) RemoveRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := s.exec(ctx, `DELETE FROM `+s.familyTable()+` WHERE family_id = ?`, familyID)
+	return err
+}
+
+func (s *SQLTokenStore) jtiTable() string {
+	return s.tableName + "_jti"
+}
+
+// createJTITable 创建已使用的 jti 表
+func (s *SQLTokenStore) createJTITable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS ` + s.jtiTable() + ` (
+		jti VARCHAR(255) NOT NULL PRIMARY KEY,
+		expires_at ` + s.timeType() + ` NOT NULL`
+	if s.dialect == DialectMySQL {
+		query += `,
+		INDEX idx_expires_at (expires_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+	} else {
+		query += `
+	)`
+	}
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	if s.dialect != DialectMySQL {
+		index := "idx_" + s.jtiTable() + "_expires_at"
+		if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS ` + index + ` ON ` + s.jtiTable() + ` (expires_at)`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseJTI 实现 JTIStore
+// 过期的记录由 CleanupExpiredTokens 定期删除, 插入之前先清理同一个 jti 的过期记录
+func (s *SQLTokenStore) UseJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	if _, err := s.exec(ctx, `DELETE FROM `+s.jtiTable()+` WHERE jti = ? AND expires_at <= ?`, jti, now()); err != nil {
+		return false, err
+	}
+	_, err := s.exec(ctx, `INSERT INTO `+s.jtiTable()+` (jti, expires_at) VALUES (?, ?)`, jti, expiresAt.UTC())
+	if err == nil {
+		return true, nil
+	}
+	// 主键冲突说明已经使用过, 其他错误原样返回
+	var n int
+	if e := s.queryRow(ctx, `SELECT COUNT(*) FROM `+s.jtiTable()+` WHERE jti = ?`, jti).Scan(&n); e == nil && n > 0 {
+		return false, nil
+	}
+	return false, err
+}
+
 // CleanupExpiredTokens 清理过期的Token
-func (s *MySQLTokenStore) CleanupExpiredTokens() error {
-	query := `DELETE FROM ` + s.tableName + ` WHERE expires_at <= ?`
-	_, err := s.db.Exec(query, time.Now())
+// access(或授权码)和refresh都过期的记录才会被删除
+func (s *SQLTokenStore) CleanupExpiredTokens() error {
+	t := now()
+	query := `DELETE FROM ` + s.tableName + ` WHERE expires_at <= ? AND (refresh_expires_at IS NULL OR refresh_expires_at <= ?)`
+	if _, err := s.exec(context.Background(), query, t, t); err != nil {
+		return err
+	}
+	if _, err := s.exec(context.Background(), `DELETE FROM `+s.familyTable()+` WHERE expires_at <= ?`, t); err != nil {
+		return err
+	}
+	if _, err := s.exec(context.Background(), `DELETE FROM `+s.deviceTable()+` WHERE expires_at <= ?`, t); err != nil {
+		return err
+	}
+	_, err := s.exec(context.Background(), `DELETE FROM `+s.jtiTable()+` WHERE expires_at <= ?`, t)
 	return err
 }
@@ -0,0 +1,176 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"oauth2/pkg/storage"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+func newSQLiteTokenStore(t *testing.T) (*storage.SQLTokenStore, *sql.DB) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "oauth2.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	s := storage.NewSQLTokenStore(db, storage.DialectSQLite, "")
+	if err := s.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	// 重复建表不应报错
+	if err := s.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+func TestSQLTokenStoreCode(t *testing.T) {
+	s, _ := newSQLiteTokenStore(t)
+	ctx := context.Background()
+
+	code := models.NewToken()
+	code.SetClientID("app_1")
+	code.SetUserID("1")
+	code.SetCode("code_1")
+	code.SetCodeCreateAt(time.Now())
+	code.SetCodeExpiresIn(time.Minute)
+	if err := s.Create(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+
+	ti, err := s.GetByCode(ctx, "code_1")
+	if err != nil || ti == nil || ti.GetUserID() != "1" {
+		t.Fatal("get by code failed:", ti, err)
+	}
+	// 授权码只能使用一次
+	if ti, _ := s.GetByCode(ctx, "code_1"); ti != nil {
+		t.Error("code should be consumed")
+	}
+
+	code.SetCode("code_2")
+	code.SetCodeCreateAt(time.Now().Add(-2 * time.Minute))
+	if err := s.Create(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	if ti, _ := s.GetByCode(ctx, "code_2"); ti != nil {
+		t.Error("code should be expired")
+	}
+}
+
+func TestSQLTokenStoreAccessRefresh(t *testing.T) {
+	s, db := newSQLiteTokenStore(t)
+	ctx := context.Background()
+
+	token := models.NewToken()
+	token.SetClientID("app_1")
+	token.SetUserID("1")
+	token.SetAccess("access_1")
+	token.SetAccessCreateAt(time.Now().Add(-2 * time.Hour))
+	token.SetAccessExpiresIn(time.Hour)
+	token.SetRefresh("refresh_1")
+	token.SetRefreshCreateAt(time.Now().Add(-2 * time.Hour))
+	token.SetRefreshExpiresIn(24 * time.Hour)
+	if err := s.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	// access 已过期, refresh 依然可用
+	if ti, _ := s.GetByAccess(ctx, "access_1"); ti != nil {
+		t.Error("access should be expired")
+	}
+	if ti, err := s.GetByRefresh(ctx, "refresh_1"); err != nil || ti == nil {
+		t.Fatal("get by refresh failed:", ti, err)
+	}
+
+	// 清理时不会删除refresh还有效的记录
+	if err := s.CleanupExpiredTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if ti, _ := s.GetByRefresh(ctx, "refresh_1"); ti == nil {
+		t.Error("refresh should survive cleanup")
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh_1"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM oauth2_tokens`).Scan(&n); err != nil || n != 0 {
+		t.Error("row should be removed:", n, err)
+	}
+}
+
+func TestSQLTokenStoreRemoveByAccess(t *testing.T) {
+	s, _ := newSQLiteTokenStore(t)
+	ctx := context.Background()
+
+	token := models.NewToken()
+	token.SetClientID("app_1")
+	token.SetAccess("access_1")
+	token.SetAccessCreateAt(time.Now())
+	token.SetAccessExpiresIn(time.Hour)
+	token.SetRefresh("refresh_1")
+	token.SetRefreshCreateAt(time.Now())
+	token.SetRefreshExpiresIn(24 * time.Hour)
+	if err := s.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if ti, err := s.GetByAccess(ctx, "access_1"); err != nil || ti == nil {
+		t.Fatal("get by access failed:", ti, err)
+	}
+
+	if err := s.RemoveByAccess(ctx, "access_1"); err != nil {
+		t.Fatal(err)
+	}
+	if ti, _ := s.GetByAccess(ctx, "access_1"); ti != nil {
+		t.Error("access should be removed")
+	}
+	if ti, _ := s.GetByRefresh(ctx, "refresh_1"); ti == nil {
+		t.Error("refresh should still be valid")
+	}
+}
+
+func TestSQLTokenStoreListTokens(t *testing.T) {
+	s, _ := newSQLiteTokenStore(t)
+	ctx := context.Background()
+
+	for i, v := range []struct{ client, user string }{{"app_1", "1"}, {"app_1", "2"}, {"app_2", "1"}} {
+		token := models.NewToken()
+		token.SetClientID(v.client)
+		token.SetUserID(v.user)
+		token.SetAccess("access_" + strconv.Itoa(i))
+		token.SetAccessCreateAt(time.Now())
+		token.SetAccessExpiresIn(time.Hour)
+		if err := s.Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens, total, err := s.ListTokens(ctx, storage.TokenFilter{ClientID: "app_1"}, 0, 10)
+	if err != nil || total != 2 || len(tokens) != 2 {
+		t.Fatal("list by client failed:", total, tokens, err)
+	}
+	// 按创建时间倒序
+	if tokens[0].GetAccess() != "access_1" {
+		t.Error("unexpected order:", tokens[0].GetAccess())
+	}
+
+	tokens, total, err = s.ListTokens(ctx, storage.TokenFilter{UserID: "1"}, 1, 1)
+	if err != nil || total != 2 || len(tokens) != 1 || tokens[0].GetAccess() != "access_0" {
+		t.Error("list by user failed:", total, tokens, err)
+	}
+
+	if err := s.RemoveByAccess(ctx, "access_0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _ := s.ListTokens(ctx, storage.TokenFilter{}, 0, 10); total != 2 {
+		t.Error("removed token should not be listed:", total)
+	}
+}